@@ -1,5 +0,0 @@
-module canonicalize
-
-go 1.24.3
-
-require github.com/mattn/go-sqlite3 v1.14.29
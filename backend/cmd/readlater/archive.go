@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// Looks up snapshots of pages through an availability API like the Wayback
+// Machine's, https://archive.org/wayback/available
+type archive struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newArchive(endpoint string) *archive {
+	if endpoint == "" {
+		return nil
+	}
+	return &archive{endpoint: endpoint, client: http.DefaultClient}
+}
+
+type availability struct {
+	ArchivedSnapshots struct {
+		Closest *struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// Wayback Machine snapshot urls show the page inside the archive's own
+// banner unless the timestamp is marked id_
+var snapshotTimestamp = regexp.MustCompile(`/web/(\d+)/`)
+
+// Returns the url of the closest snapshot of the page, or the empty string
+// if the archive has none
+func (a *archive) snapshot(ctx context.Context, page string) (string, error) {
+	lookup, err := url.Parse(a.endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive URL: %w", err)
+	}
+	query := lookup.Query()
+	query.Set("url", page)
+	lookup.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookup.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("archive returned %s", resp.Status)
+	}
+	var result availability
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("unreadable archive response: %w", err)
+	}
+	closest := result.ArchivedSnapshots.Closest
+	if closest == nil || !closest.Available || closest.URL == "" {
+		return "", nil
+	}
+	return snapshotTimestamp.ReplaceAllString(closest.URL, "/web/${1}id_/"), nil
+}
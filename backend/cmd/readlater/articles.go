@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Finds the article a request is about, returning its url in the user's
+// library, or false having written the error
+type articleLocator func(w http.ResponseWriter, r *http.Request, db library.Repo, user library.User) (string, bool)
+
+// Routes under /api/articles/{id} identify articles by their id, which
+// unlike their url needs no escaping and doesn't change when the article
+// is canonicalized or merged into
+func articleFromPath(w http.ResponseWriter, r *http.Request, db library.Repo, user library.User) (string, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		logError(w, fmt.Sprintf("Invalid article id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return "", false
+	}
+	url, ok := db.ArticleURL(r.Context(), user, id)
+	if !ok {
+		logError(w, fmt.Sprintf("No such article: %d", id), http.StatusNotFound)
+		return "", false
+	}
+	return url, true
+}
+
+// Older routes identify articles by the url parameter
+func articleFromQuery(w http.ResponseWriter, r *http.Request, _ library.Repo, _ library.User) (string, bool) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		logError(w, "No URL provided", http.StatusBadRequest)
+		return "", false
+	}
+	return url, true
+}
+
+// Returns an article in the user's library without marking it read
+func getArticle(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		url, ok := articleFromPath(w, r, db, user)
+		if !ok {
+			return
+		}
+		article, ok := db.LibraryArticle(r.Context(), user, url)
+		if !ok {
+			logError(w, fmt.Sprintf("Not in library: %s", url), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(article)
+	}
+}
+
+// Changes the fields of the article's library entry given in the request.
+// The change is made now, so it wins over older changes from devices that
+// were offline.
+func patchArticle(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		url, ok := articleFromPath(w, r, db, user)
+		if !ok {
+			return
+		}
+		var req struct {
+			Unread   *bool `json:"unread"`
+			Archived *bool `json:"archived"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+			return
+		}
+		now := time.Now().UnixMilli()
+		var mutations []library.Mutation
+		if req.Unread != nil {
+			mutations = append(mutations, library.Mutation{Url: url, Field: "unread", Value: *req.Unread, Timestamp: now})
+		}
+		if req.Archived != nil {
+			mutations = append(mutations, library.Mutation{Url: url, Field: "archived", Value: *req.Archived, Timestamp: now})
+		}
+		results, err := db.ApplyMutations(r.Context(), user, "", mutations)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error updating article: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				logError(w, fmt.Sprintf("Error updating article: %s", result.Error), http.StatusNotFound)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Articles listed at once unless the client asks for more or fewer, and
+// the most it can ask for
+const (
+	listDefaultCount = 20
+	listMaxCount     = 500
+)
+
+// Lists the articles in the user's library a page at a time. The query
+// parameters, all optional, are:
+//
+//	unread, archived     true or false to list only those that are or aren't
+//	tag                  a tag, including those nested under it
+//	domain               a site, including its subdomains
+//	createdSince, createdBefore, lastAccessSince, lastAccessBefore
+//	                     an RFC 3339 time or a date
+//	sort                 created (the default), lastAccess, title or length
+//	order                asc or desc; titles are ascending by default and
+//	                     everything else descending
+//	count                how many articles to list
+//	cursor               from the previous page, to list the next one
+//
+// The response holds the articles and the cursor for the next page, which
+// is empty after the last page.
+func listArticles(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		q, cursor, err := parseListQuery(r.URL.Query())
+		if err != nil {
+			logError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list, next, err := db.ListArticles(r.Context(), user, q)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error listing articles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var response struct {
+			Articles library.ArticleList `json:"articles"`
+			Cursor   string              `json:"cursor"`
+		}
+		response.Articles = list
+		if response.Articles == nil {
+			response.Articles = library.ArticleList{}
+		}
+		if next != nil {
+			cursor.Position = *next
+			response.Cursor = encodeListCursor(cursor)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Listing cursors hold the position a page ended at along with the order
+// it was listed in, since a position only means something in that order.
+// They are opaque to clients.
+type listCursor struct {
+	Sort      string               `json:"s"`
+	Ascending bool                 `json:"a,omitempty"`
+	Position  library.ListPosition `json:"p"`
+}
+
+func encodeListCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(cursor string) (listCursor, error) {
+	var c listCursor
+	invalid := fmt.Errorf("invalid cursor: %s", cursor)
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, invalid
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, invalid
+	}
+	return c, nil
+}
+
+// Reads a listing query from request parameters, returning it with the
+// cursor for its pages
+func parseListQuery(params url.Values) (library.ListQuery, listCursor, error) {
+	q := library.ListQuery{
+		Sort:   "created",
+		Tag:    params.Get("tag"),
+		Domain: strings.TrimPrefix(strings.ToLower(params.Get("domain")), "www."),
+		Limit:  listDefaultCount,
+	}
+	var err error
+	for name, flag := range map[string]**bool{"unread": &q.Unread, "archived": &q.Archived} {
+		if value := params.Get(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return q, listCursor{}, fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*flag = &b
+		}
+	}
+	for name, t := range map[string]*time.Time{
+		"createdSince":     &q.CreatedSince,
+		"createdBefore":    &q.CreatedBefore,
+		"lastAccessSince":  &q.LastAccessSince,
+		"lastAccessBefore": &q.LastAccessBefore,
+	} {
+		if value := params.Get(name); value != "" {
+			if *t, err = parseListTime(value); err != nil {
+				return q, listCursor{}, fmt.Errorf("invalid %s: %s", name, value)
+			}
+		}
+	}
+	if sort := params.Get("sort"); sort != "" {
+		if !slices.Contains(library.ListSorts, sort) {
+			return q, listCursor{}, fmt.Errorf("invalid sort: %s", sort)
+		}
+		q.Sort = sort
+	}
+	q.Ascending = q.Sort == "title"
+	switch order := params.Get("order"); order {
+	case "":
+	case "asc":
+		q.Ascending = true
+	case "desc":
+		q.Ascending = false
+	default:
+		return q, listCursor{}, fmt.Errorf("invalid order: %s", order)
+	}
+	if countStr := params.Get("count"); countStr != "" {
+		q.Limit, err = strconv.Atoi(countStr)
+		if err != nil || q.Limit <= 0 {
+			return q, listCursor{}, fmt.Errorf("invalid count: %s", countStr)
+		}
+		q.Limit = min(q.Limit, listMaxCount)
+	}
+
+	cursor := listCursor{Sort: q.Sort, Ascending: q.Ascending}
+	if value := params.Get("cursor"); value != "" {
+		c, err := decodeListCursor(value)
+		if err != nil {
+			return q, listCursor{}, err
+		}
+		if c.Sort != cursor.Sort || c.Ascending != cursor.Ascending {
+			return q, listCursor{}, errors.New("cursor is from a listing in another order")
+		}
+		q.After = &c.Position
+	}
+	return q, cursor, nil
+}
+
+// Times in listing queries are RFC 3339 times or dates, taken as midnight
+// UTC
+func parseListTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
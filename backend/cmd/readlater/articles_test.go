@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func TestArticleIds(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	other := library.User("other@example.com")
+
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/a?utm_source=x", Title: "A", Contents: "aaa"}, "2024-01-01 00:00:00"))
+	art := &library.Article{Url: "https://example.com/b", Title: "B", Contents: "bbb"}
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, art, "2024-01-02 00:00:00"))
+	assert.Assert(t, art.Id != 0)
+
+	list, err := db.Recents(ctx, user, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(list))
+	ids := map[string]int64{}
+	for _, entry := range list {
+		assert.Assert(t, entry.Id != 0)
+		ids[entry.Url] = entry.Id
+	}
+	assert.Equal(t, art.Id, ids["https://example.com/b"])
+
+	do := func(handler AuthHandlerFunc, method string, id int64, body string, u library.User) *http.Response {
+		req := httptest.NewRequest(method, fmt.Sprintf("/api/articles/%d", id), strings.NewReader(body))
+		req.SetPathValue("id", fmt.Sprint(id))
+		w := httptest.NewRecorder()
+		handler(w, req, u)
+		return w.Result()
+	}
+	get := func(id int64) library.Article {
+		resp := do(getArticle(db), http.MethodGet, id, "", user)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var art library.Article
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(&art))
+		return art
+	}
+
+	// the id outlives the url
+	id := ids["https://example.com/a?utm_source=x"]
+	assert.NilError(t, canonicalizeCommand(db, nil))
+	got := get(id)
+	assert.Equal(t, id, got.Id)
+	assert.Equal(t, "https://example.com/a", got.Url)
+	assert.Equal(t, "aaa", got.Contents)
+
+	// reading it doesn't mark it read, but patching it does
+	list, err = db.Recents(ctx, user, 5)
+	assert.NilError(t, err)
+	for _, entry := range list {
+		assert.Assert(t, entry.Unread)
+	}
+	assert.Equal(t, http.StatusOK, do(patchArticle(db), http.MethodPatch, id, `{"unread": false, "archived": true}`, user).StatusCode)
+	list, _, err = db.ListArticles(ctx, user, library.ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	for _, entry := range list {
+		if entry.Id == id {
+			assert.Assert(t, !entry.Unread)
+			assert.Assert(t, entry.Archived)
+		} else {
+			assert.Assert(t, entry.Unread)
+			assert.Assert(t, !entry.Archived)
+		}
+	}
+	assert.Equal(t, http.StatusBadRequest, do(patchArticle(db), http.MethodPatch, id, `{"unread": "no"}`, user).StatusCode)
+
+	// other users can't see the article by its id
+	assert.Equal(t, http.StatusNotFound, do(getArticle(db), http.MethodGet, id, "", other).StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(trashArticle(db, articleFromPath), http.MethodDelete, id, "", other).StatusCode)
+
+	// deleting it puts it in the trash
+	assert.Equal(t, http.StatusOK, do(trashArticle(db, articleFromPath), http.MethodDelete, id, "", user).StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(getArticle(db), http.MethodGet, id, "", user).StatusCode)
+	list, _, err = db.ListArticles(ctx, user, library.ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, art.Id, list[0].Id)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/articles/x", nil)
+	req.SetPathValue("id", "x")
+	w := httptest.NewRecorder()
+	getArticle(db)(w, req, user)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestListArticles(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+
+	for i, a := range []struct{ url, title, contents string }{
+		{"https://example.com/1", "delta", "xx"},
+		{"https://news.example.com/2", "Alpha", "xxxx"},
+		{"https://example.org/3", "charlie", "x"},
+		{"https://notexample.com/4", "bravo", "xxx"},
+		{"https://example.org/5", "echo", "xxxxx"},
+	} {
+		created := fmt.Sprintf("2024-01-0%d 12:00:00", i+1)
+		assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: a.url, Title: a.title, Contents: a.contents}, created))
+	}
+	assert.NilError(t, db.SetArchive(ctx, user, "https://example.org/3", true))
+	assert.NilError(t, db.MarkRead(ctx, user, "https://example.com/1"))
+	_, err = db.DB().Exec("UPDATE library SET lastAccess = '2030-01-01 00:00:00' WHERE url = 'https://example.com/1'")
+	assert.NilError(t, err)
+	_, err = db.AddTags(ctx, user, "https://example.org/5", []string{"work/later"})
+	assert.NilError(t, err)
+
+	type page struct {
+		Articles library.ArticleList `json:"articles"`
+		Cursor   string              `json:"cursor"`
+	}
+	list := func(query string) (page, int) {
+		req := httptest.NewRequest(http.MethodGet, "/api/articles?"+query, nil)
+		w := httptest.NewRecorder()
+		listArticles(db)(w, req, user)
+		var p page
+		if w.Result().StatusCode == http.StatusOK {
+			assert.NilError(t, json.NewDecoder(w.Result().Body).Decode(&p))
+		}
+		return p, w.Result().StatusCode
+	}
+	titles := func(query string) []string {
+		p, status := list(query)
+		assert.Equal(t, http.StatusOK, status, query)
+		result := []string{}
+		for _, a := range p.Articles {
+			result = append(result, a.Title)
+		}
+		return result
+	}
+
+	// pages follow each other, even when articles are added in between
+	p, _ := list("count=2")
+	assert.Equal(t, 2, len(p.Articles))
+	assert.Equal(t, "echo", p.Articles[0].Title)
+	assert.Assert(t, p.Cursor != "")
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/6", Title: "foxtrot"}, "2024-01-09 00:00:00"))
+	var seen []string
+	for p.Cursor != "" {
+		for _, a := range p.Articles {
+			seen = append(seen, a.Title)
+		}
+		p, _ = list("count=2&cursor=" + p.Cursor)
+	}
+	seen = append(seen, p.Articles[0].Title)
+	assert.DeepEqual(t, []string{"echo", "bravo", "charlie", "Alpha", "delta"}, seen)
+
+	// sorts
+	assert.DeepEqual(t, []string{"Alpha", "bravo", "charlie", "delta", "echo", "foxtrot"}, titles("sort=title"))
+	assert.DeepEqual(t, []string{"echo", "Alpha", "bravo", "delta", "charlie", "foxtrot"}, titles("sort=length"))
+	assert.DeepEqual(t, []string{"foxtrot", "charlie"}, titles("sort=length&order=asc&count=2"))
+	assert.DeepEqual(t, []string{"delta"}, titles("sort=lastAccess&count=1"))
+
+	// filters
+	assert.DeepEqual(t, []string{"charlie"}, titles("archived=true"))
+	assert.DeepEqual(t, []string{"delta"}, titles("unread=false"))
+	assert.DeepEqual(t, []string{"echo"}, titles("tag=work"))
+	assert.DeepEqual(t, []string{"Alpha", "delta", "foxtrot"}, titles("domain=www.example.com&sort=title"))
+	assert.DeepEqual(t, []string{"bravo", "charlie"}, titles("createdSince=2024-01-03&createdBefore=2024-01-04T12:00:01Z"))
+	assert.DeepEqual(t, []string{}, titles("lastAccessBefore=2000-01-01"))
+
+	// the wrappers
+	recents, err := db.Recents(ctx, user, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 5, len(recents))
+	assert.Equal(t, "delta", recents[0].Title)
+	archive, err := db.Archive(ctx, user, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(archive))
+
+	// a cursor only continues the listing it came from
+	p, _ = list("count=1")
+	_, status := list("count=1&sort=title&cursor=" + p.Cursor)
+	assert.Equal(t, http.StatusBadRequest, status)
+	for _, query := range []string{"cursor=nonsense", "sort=size", "order=up", "unread=maybe", "count=0", "createdSince=yesterday"} {
+		_, status := list(query)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
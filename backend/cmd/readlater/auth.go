@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+type AuthHandlerFunc func(http.ResponseWriter, *http.Request, library.User)
+
+const sessionCookie = "session"
+
+// The frontend stores the raw Google credential in this cookie
+const googleTokenCookie = "auth_token"
+
+func noAuth() func(AuthHandlerFunc) http.HandlerFunc {
+	return func(next AuthHandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(w, r, "")
+		}
+	}
+}
+
+// authenticator admits requests carrying a valid session cookie or API
+// token, or the Google ID token in the cookie the frontend sets after Google
+// sign-in. Sessions are only created from ID tokens posted to the login
+// endpoint, so that clients that don't keep the session cookie don't start
+// a new session with every request.
+type authenticator struct {
+	db           library.Repo
+	verifier     *idTokenVerifier
+	sessionTTL   time.Duration
+	allowedUsers []string
+}
+
+func newAuthenticator(db library.Repo, verifier *idTokenVerifier, sessionTTL time.Duration, allowedUsers []string) *authenticator {
+	return &authenticator{db, verifier, sessionTTL, allowedUsers}
+}
+
+// Returns middleware that is a drop-in replacement for noAuth()
+func (a *authenticator) middleware() func(AuthHandlerFunc) http.HandlerFunc {
+	return func(next AuthHandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if user, scopes, ok := a.db.TokenUser(r.Context(), token); ok {
+					next(w, withScopes(r, scopes), user)
+					return
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if cookie, err := r.Cookie(sessionCookie); err == nil {
+				if user, ok := a.db.SessionUser(r.Context(), cookie.Value); ok {
+					next(w, r, user)
+					return
+				}
+			}
+			if cookie, err := r.Cookie(googleTokenCookie); err == nil {
+				user, err := a.verify(r, cookie.Value)
+				if err == nil {
+					next(w, r, user)
+					return
+				}
+				log.Printf("rejecting Google credential: %v", err)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}
+}
+
+// Verifies a Google ID token and returns its user if they are allowed.
+// Anyone can get an ID token for the client id, so nobody is allowed unless
+// they are listed.
+func (a *authenticator) verify(r *http.Request, idToken string) (library.User, error) {
+	claims, err := a.verifier.Verify(r.Context(), idToken)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(a.allowedUsers, claims.Email) {
+		return "", fmt.Errorf("user %s is not allowed", claims.Email)
+	}
+	return library.User(claims.Email), nil
+}
+
+// Verifies a Google ID token and, if it belongs to an allowed user, issues
+// a session cookie for them
+func (a *authenticator) startSession(w http.ResponseWriter, r *http.Request, idToken string) (library.User, error) {
+	user, err := a.verify(r, idToken)
+	if err != nil {
+		return "", err
+	}
+	if err := a.db.PruneSessions(r.Context()); err != nil {
+		log.Printf("Error pruning sessions: %v", err)
+	}
+	token, err := a.db.CreateSession(r.Context(), user, a.sessionTTL)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(a.sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return user, nil
+}
+
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func (a *authenticator) login() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Credential string `json:"credential"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+			return
+		}
+		user, err := a.startSession(w, r, req.Credential)
+		if err != nil {
+			logError(w, fmt.Sprintf("Login failed: %v", err), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			User library.User `json:"user"`
+		}{user})
+	}
+}
+
+func (a *authenticator) logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			err = a.db.DeleteSession(r.Context(), cookie.Value)
+			if err != nil {
+				logError(w, fmt.Sprintf("Error deleting session: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   isSecure(r),
+			SameSite: http.SameSiteStrictMode,
+		})
+		w.WriteHeader(http.StatusOK)
+	}
+}
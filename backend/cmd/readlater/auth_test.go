@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+const testClientId = "test-client.apps.googleusercontent.com"
+
+func writeJwks(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	jwks := map[string][]jwk{
+		"keys": {{
+			Kid: kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(jwks)
+	assert.NilError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NilError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims idTokenClaims) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	assert.NilError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NilError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.NilError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() idTokenClaims {
+	now := time.Now()
+	return idTokenClaims{
+		Issuer:        "https://accounts.google.com",
+		Audience:      testClientId,
+		Subject:       "1234",
+		Email:         "test@example.com",
+		EmailVerified: true,
+		IssuedAt:      now.Unix(),
+		Expires:       now.Add(time.Hour).Unix(),
+	}
+}
+
+func authRequest(handler http.HandlerFunc, cookies ...*http.Cookie) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/api/recents", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w.Result()
+}
+
+func TestIdTokenVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	verifier := newIdTokenVerifier(testClientId, writeJwks(t, key, "k1"), "")
+	ctx := context.Background()
+
+	claims, err := verifier.Verify(ctx, signToken(t, key, "k1", validClaims()))
+	assert.NilError(t, err)
+	assert.Equal(t, "test@example.com", claims.Email)
+
+	bad := validClaims()
+	bad.Audience = "someone-else"
+	_, err = verifier.Verify(ctx, signToken(t, key, "k1", bad))
+	assert.ErrorContains(t, err, "another client")
+
+	bad = validClaims()
+	bad.Expires = time.Now().Add(-time.Hour).Unix()
+	_, err = verifier.Verify(ctx, signToken(t, key, "k1", bad))
+	assert.ErrorContains(t, err, "expired")
+
+	bad = validClaims()
+	bad.Issuer = "https://evil.example.com"
+	_, err = verifier.Verify(ctx, signToken(t, key, "k1", bad))
+	assert.ErrorContains(t, err, "issuer")
+
+	bad = validClaims()
+	bad.EmailVerified = false
+	_, err = verifier.Verify(ctx, signToken(t, key, "k1", bad))
+	assert.ErrorContains(t, err, "verified email")
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	_, err = verifier.Verify(ctx, signToken(t, otherKey, "k1", validClaims()))
+	assert.ErrorContains(t, err, "signature")
+
+	_, err = verifier.Verify(ctx, "not.a.token")
+	assert.Assert(t, err != nil)
+}
+
+func TestIdTokenVerifierUrl(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	jwks, err := os.ReadFile(writeJwks(t, key, "k1"))
+	assert.NilError(t, err)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks)
+	}))
+	defer stub.Close()
+
+	verifier := newIdTokenVerifier(testClientId, "", stub.URL)
+	_, err = verifier.Verify(context.Background(), signToken(t, key, "k1", validClaims()))
+	assert.NilError(t, err)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	verifier := newIdTokenVerifier(testClientId, writeJwks(t, key, "k1"), "")
+	auth := newAuthenticator(db, verifier, time.Hour, []string{"test@example.com"})
+
+	var seen library.User
+	handler := auth.middleware()(func(w http.ResponseWriter, r *http.Request, user library.User) {
+		seen = user
+	})
+
+	// no credentials at all
+	resp := authRequest(handler)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// a bogus session
+	resp = authRequest(handler, &http.Cookie{Name: sessionCookie, Value: "bogus"})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// a Google credential is enough, without starting a session each time
+	idToken := signToken(t, key, "k1", validClaims())
+	for range 2 {
+		seen = ""
+		resp = authRequest(handler, &http.Cookie{Name: googleTokenCookie, Value: idToken})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, library.User("test@example.com"), seen)
+		assert.Equal(t, 0, len(resp.Cookies()))
+	}
+	var sessions int
+	assert.NilError(t, db.DB().QueryRow("SELECT count(*) FROM sessions").Scan(&sessions))
+	assert.Equal(t, 0, sessions)
+
+	// users not on the allow list are turned away
+	claims := validClaims()
+	claims.Email = "stranger@example.com"
+	resp = authRequest(handler, &http.Cookie{Name: googleTokenCookie, Value: signToken(t, key, "k1", claims)})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// explicit login and logout
+	body, err := json.Marshal(map[string]string{"credential": idToken})
+	assert.NilError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	auth.login()(w, req)
+	resp = w.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	session := resp.Cookies()[0]
+	assert.Equal(t, sessionCookie, session.Name)
+	assert.Assert(t, session.HttpOnly)
+
+	// the session alone is enough from then on
+	seen = ""
+	resp = authRequest(handler, session)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, library.User("test@example.com"), seen)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/logout", nil)
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	auth.logout()(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	resp = authRequest(handler, session)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
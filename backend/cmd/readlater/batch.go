@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"github.com/rcbilson/readlater/www"
+)
+
+type batchOptions struct {
+	Concurrency int
+	// How many fetches from one host may run at once, and how long to wait
+	// between starting them
+	PerHost   int
+	HostDelay time.Duration
+	// How long each record may take; zero for no limit
+	Timeout time.Duration
+	// Records the urls of the records that are done, so that a rerun
+	// resumes where the last one stopped; empty for none
+	Checkpoint string
+	// Where the records that failed are written, for a later retry; empty
+	// for no report
+	Failures string
+}
+
+// Adds the flags for the options shared by the commands that fetch many
+// articles at once
+func batchFlags(flags *flag.FlagSet) *batchOptions {
+	var opts batchOptions
+	flags.IntVar(&opts.Concurrency, "concurrency", 4, "how many articles to process at once")
+	flags.IntVar(&opts.PerHost, "per-host", 1, "how many articles to fetch from one host at once")
+	flags.DurationVar(&opts.HostDelay, "host-delay", time.Second, "time between starting fetches from one host")
+	flags.DurationVar(&opts.Timeout, "timeout", 2*time.Minute, "time allowed for each article, or 0 for no limit")
+	return &opts
+}
+
+// Limits the fetches made from each host so that importing many articles
+// from one site doesn't hammer it
+type hostLimiter struct {
+	perHost int
+	delay   time.Duration
+	mu      sync.Mutex
+	slots   map[string]chan struct{}
+	next    map[string]time.Time
+}
+
+func newHostLimiter(perHost int, delay time.Duration) *hostLimiter {
+	return &hostLimiter{
+		perHost: max(perHost, 1),
+		delay:   delay,
+		slots:   map[string]chan struct{}{},
+		next:    map[string]time.Time{},
+	}
+}
+
+// Waits until a fetch from the host may start, returning a function to call
+// once it has finished
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	l.mu.Lock()
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.perHost)
+		l.slots[host] = slot
+	}
+	l.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-slot }
+
+	l.mu.Lock()
+	start := time.Now()
+	if l.next[host].After(start) {
+		start = l.next[host]
+	}
+	l.next[host] = start.Add(l.delay)
+	l.mu.Unlock()
+	select {
+	case <-time.After(time.Until(start)):
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// The urls of the records finished in earlier runs
+func readCheckpoint(filename string) (map[string]bool, error) {
+	done := map[string]bool{}
+	if filename == "" {
+		return done, nil
+	}
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		done[scanner.Text()] = true
+	}
+	return done, scanner.Err()
+}
+
+// A record that failed to import, as written to the failure report. The
+// report can itself be imported to try them again.
+type failure struct {
+	URL       string   `json:"url"`
+	Title     string   `json:"title"`
+	TimeAdded int64    `json:"timeAdded"`
+	Tags      []string `json:"tags"`
+	Archived  bool     `json:"archived"`
+	Error     string   `json:"error"`
+}
+
+func writeFailures(filename string, failures []failure) error {
+	if filename == "" {
+		return nil
+	}
+	if len(failures) == 0 {
+		// Don't leave a report of earlier failures lying around
+		err := os.Remove(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+type outcome int
+
+const (
+	imported outcome = iota
+	skipped
+	// Couldn't be fetched, so only the title was kept
+	placeholder
+	refetched
+	// Refetched, but the article hadn't changed
+	unchanged
+	failed
+)
+
+var outcomeLabels = map[outcome]string{
+	imported:    "✓ Imported successfully",
+	skipped:     "✓ Already exists, skipping",
+	placeholder: "✗ Unreachable, saved placeholder",
+	refetched:   "✓ Refetched",
+	unchanged:   "✓ Unchanged",
+	failed:      "✗ Failed",
+}
+
+type result struct {
+	record  record
+	outcome outcome
+	err     error
+}
+
+// Imports one record, giving up once it has taken the timeout
+func importRecord(ctx context.Context, record record, db library.Repo, user library.User, fetcher www.FetcherFunc, summarizer library.SummarizeFunc, limiter *hostLimiter, timeout time.Duration) result {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Check if article already exists
+	if url, exists := libraryURL(ctx, db, user, record.URL); exists {
+		if err := organizeRecord(ctx, record, db, user, url); err != nil {
+			return result{record, failed, err}
+		}
+		return result{record, skipped, nil}
+	}
+
+	// Exports that carry the article don't need to fetch it
+	if record.Html == nil {
+		parsed, err := url.Parse(record.URL)
+		if err != nil {
+			return result{record, failed, fmt.Errorf("invalid URL: %w", err)}
+		}
+		release, err := limiter.acquire(ctx, parsed.Host)
+		if err != nil {
+			return result{record, failed, err}
+		}
+		defer release()
+	}
+
+	err := processRecord(ctx, record, db, user, fetcher, summarizer)
+	if errors.Is(err, library.ErrFetchFailed) {
+		// Keep the title so that the article isn't lost; refetching may
+		// fetch it later. The record may have used up its time already.
+		createdTime := record.created().UTC().Format("2006-01-02 15:04:05")
+		title := record.Title
+		if title == "" {
+			title = record.URL
+		}
+		url, cerr := db.CanonicalizeURL(record.URL)
+		if cerr != nil {
+			url = record.URL
+		}
+		ctx := context.WithoutCancel(ctx)
+		art := &library.Article{Title: title, Url: url}
+		if perr := db.InsertPlaceholder(ctx, user, art, createdTime, err.Error()); perr != nil {
+			return result{record, failed, fmt.Errorf("%w (and failed to save placeholder: %w)", err, perr)}
+		}
+		if err := organizeRecord(ctx, record, db, user, url); err != nil {
+			return result{record, failed, err}
+		}
+		return result{record, placeholder, err}
+	}
+	if err != nil {
+		return result{record, failed, err}
+	}
+	return result{record, imported, nil}
+}
+
+func processRecords(records []record, db library.Repo, user library.User, fetcher www.FetcherFunc, summarizer library.SummarizeFunc, opts batchOptions) error {
+	limiter := newHostLimiter(opts.PerHost, opts.HostDelay)
+	counts, err := runBatch(records, opts, func(ctx context.Context, record record) result {
+		return importRecord(ctx, record, db, user, fetcher, summarizer, limiter, opts.Timeout)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nImport summary:\n")
+	fmt.Printf("  Total records: %d\n", counts.total)
+	fmt.Printf("  Successfully imported: %d\n", counts.outcomes[imported])
+	fmt.Printf("  Skipped (already exist): %d\n", counts.outcomes[skipped])
+	fmt.Printf("  Saved as placeholders: %d\n", counts.outcomes[placeholder])
+	fmt.Printf("  Failed: %d\n", counts.outcomes[failed])
+	if counts.outcomes[failed] > 0 && opts.Failures != "" {
+		fmt.Printf("  Failures written to %s\n", opts.Failures)
+	}
+	return nil
+}
+
+type batchCounts struct {
+	total    int
+	outcomes map[outcome]int
+}
+
+// Runs process over the records that aren't done yet, keeping the
+// checkpoint and the failure report
+func runBatch(records []record, opts batchOptions, process func(context.Context, record) result) (batchCounts, error) {
+	ctx := context.Background()
+	done, err := readCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return batchCounts{}, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	checkpoint := io.Discard
+	if opts.Checkpoint != "" {
+		file, err := os.OpenFile(opts.Checkpoint, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return batchCounts{}, fmt.Errorf("failed to open checkpoint: %w", err)
+		}
+		defer file.Close()
+		checkpoint = file
+	}
+
+	var pending []record
+	for _, record := range records {
+		if !done[record.URL] {
+			pending = append(pending, record)
+		}
+	}
+	counts := batchCounts{total: len(pending), outcomes: map[outcome]int{}}
+	var failures []failure
+
+	fmt.Printf("\nProcessing %d records", counts.total)
+	if resumed := len(records) - counts.total; resumed > 0 {
+		fmt.Printf(" (%d done in an earlier run)", resumed)
+	}
+	fmt.Printf(" with %d workers...\n\n", max(opts.Concurrency, 1))
+
+	work := make(chan record)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for range max(opts.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for record := range work {
+				results <- process(ctx, record)
+			}
+		}()
+	}
+	go func() {
+		for _, record := range pending {
+			work <- record
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	count := 0
+	for res := range results {
+		count++
+		counts.outcomes[res.outcome]++
+		if res.err != nil {
+			fmt.Printf("[%d/%d] %s: %s: %v\n", count, counts.total, outcomeLabels[res.outcome], res.record.URL, res.err)
+		} else {
+			fmt.Printf("[%d/%d] %s: %s\n", count, counts.total, outcomeLabels[res.outcome], res.record.URL)
+		}
+		if res.outcome == failed {
+			failures = append(failures, failure{
+				URL:       res.record.URL,
+				Title:     res.record.Title,
+				TimeAdded: res.record.TimeAdded,
+				Tags:      res.record.Tags,
+				Archived:  res.record.Archived,
+				Error:     res.err.Error(),
+			})
+			continue
+		}
+		if _, err := fmt.Fprintln(checkpoint, res.record.URL); err != nil {
+			return counts, fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+	}
+
+	if err := writeFailures(opts.Failures, failures); err != nil {
+		return counts, fmt.Errorf("failed to write failure report: %w", err)
+	}
+	return counts, nil
+}
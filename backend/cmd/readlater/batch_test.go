@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+// Fetches made up pages, keeping track of how many fetches from each host
+// run at once
+type fakeWeb struct {
+	mu      sync.Mutex
+	running map[string]int
+	most    map[string]int
+	fetched []string
+}
+
+func (f *fakeWeb) fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+	parsed, _ := url.Parse(rawURL)
+	f.mu.Lock()
+	f.running[parsed.Host]++
+	f.most[parsed.Host] = max(f.most[parsed.Host], f.running[parsed.Host])
+	f.fetched = append(f.fetched, rawURL)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.running[parsed.Host]--
+		f.mu.Unlock()
+	}()
+
+	switch {
+	case parsed.Host == "archive.example.org":
+		return []byte("# Archived " + parsed.Path + "\nwords"), rawURL, nil
+	case strings.Contains(rawURL, "missing"):
+		return nil, "", errors.New("404 not found")
+	case strings.Contains(rawURL, "slow"):
+		<-ctx.Done()
+		return nil, "", ctx.Err()
+	}
+	time.Sleep(5 * time.Millisecond)
+	return []byte("# Page at " + rawURL + "\nwords"), rawURL, nil
+}
+
+func TestProcessRecords(t *testing.T) {
+	dir := t.TempDir()
+	db, err := library.NewRepo(filepath.Join(dir, "readlater.db"), nil)
+	assert.NilError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	web := &fakeWeb{running: map[string]int{}, most: map[string]int{}}
+	summarizer := func(_ context.Context, _ string, html []byte) (library.Summary, error) {
+		if strings.Contains(string(html), "garbled") {
+			return library.Summary{}, errors.New("unreadable page")
+		}
+		return library.Summary{Contents: string(html)}, nil
+	}
+	opts := batchOptions{
+		Concurrency: 4,
+		PerHost:     1,
+		Timeout:     100 * time.Millisecond,
+		Checkpoint:  filepath.Join(dir, "export.progress"),
+		Failures:    filepath.Join(dir, "export.failures.json"),
+	}
+
+	records := []record{
+		{URL: "https://a.example.com/1", TimeAdded: 1700000000},
+		{URL: "https://a.example.com/2", TimeAdded: 1700000000},
+		{URL: "https://a.example.com/missing", Title: "Gone", Tags: []string{"old"}, TimeAdded: 1700000000},
+		{URL: "https://b.example.com/1", TimeAdded: 1700000000},
+		{URL: "https://b.example.com/2", TimeAdded: 1700000000},
+		{URL: "https://c.example.com/slow", TimeAdded: 1700000000},
+		{URL: "https://b.example.com/garbled", Title: "Mangled", Tags: []string{"old"}, TimeAdded: 1700000000},
+	}
+	assert.NilError(t, processRecords(records, db, user, web.fetch, summarizer, opts))
+
+	// each host is only fetched from once at a time
+	assert.Equal(t, 1, web.most["a.example.com"])
+	assert.Equal(t, 1, web.most["b.example.com"])
+	_, ok := db.LibraryArticle(ctx, user, "https://b.example.com/2")
+	assert.Assert(t, ok)
+
+	// unreachable articles are kept as placeholders
+	gone, ok := db.LibraryArticle(ctx, user, "https://a.example.com/missing")
+	assert.Assert(t, ok)
+	assert.Equal(t, "Gone", gone.Title)
+	assert.Equal(t, "", gone.Contents)
+
+	checkpoint, err := os.ReadFile(opts.Checkpoint)
+	assert.NilError(t, err)
+	assert.Equal(t, 6, len(strings.Fields(string(checkpoint))))
+
+	// the failures can be imported again
+	report, err := os.ReadFile(opts.Failures)
+	assert.NilError(t, err)
+	imp, err := findImporter("auto", report)
+	assert.NilError(t, err)
+	assert.Equal(t, "failures", imp.Name())
+	failures, err := imp.Parse(report)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(failures))
+	assert.Equal(t, "Mangled", failures[0].Title)
+	assert.DeepEqual(t, []string{"old"}, failures[0].Tags)
+	assert.Assert(t, strings.Contains(string(report), "unreadable page"))
+
+	// a rerun only tries the records that aren't done
+	web.fetched = nil
+	assert.NilError(t, processRecords(records, db, user, web.fetch, summarizer, opts))
+	assert.Equal(t, 1, len(web.fetched))
+
+	// refetching the failed articles finds those the archive has a snapshot of the archive has a snapshot of
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("url")
+		if !strings.Contains(page, "missing") {
+			w.Write([]byte(`{"archived_snapshots": {}}`))
+			return
+		}
+		w.Write([]byte(`{"archived_snapshots": {"closest": {"available": true, "status": "200",
+			"url": "http://archive.example.org/web/20200101000000/` + page + `", "timestamp": "20200101000000"}}}`))
+	}))
+	defer stub.Close()
+	refreshOpts := batchOptions{Concurrency: 2, PerHost: 1, Timeout: 100 * time.Millisecond}
+	failed, err := db.StoredArticles(ctx, true, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(failed))
+	assert.NilError(t, refetchArticles(failed, db, web.fetch, summarizer, newArchive(stub.URL), false, refreshOpts))
+
+	gone, ok = db.LibraryArticle(ctx, user, "https://a.example.com/missing")
+	assert.Assert(t, ok)
+	assert.Equal(t, "Archived /web/20200101000000id_/https://a.example.com/missing", gone.Title)
+	assert.Equal(t, library.StatusReady, gone.Status)
+	failed, err = db.StoredArticles(ctx, true, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(failed))
+	assert.Equal(t, "https://c.example.com/slow", failed[0].Url)
+
+	// refetching articles whose pages haven't changed leaves them be
+	web.fetched = nil
+	stored, err := db.StoredArticles(ctx, false, 0)
+	assert.NilError(t, err)
+	before, _ := db.LibraryArticle(ctx, user, "https://a.example.com/1")
+	assert.NilError(t, refetchArticles(stored, db, web.fetch, summarizer, nil, false, refreshOpts))
+	assert.Equal(t, len(stored), len(web.fetched))
+	after, _ := db.LibraryArticle(ctx, user, "https://a.example.com/1")
+	assert.DeepEqual(t, before, after)
+}
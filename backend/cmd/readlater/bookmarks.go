@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Reads the Netscape bookmarks file that browsers export. The folders a
+// bookmark is in become a tag, along with any tags of its own.
+type bookmarksImporter struct{}
+
+func (bookmarksImporter) Name() string { return "bookmarks" }
+
+func (bookmarksImporter) Detect(data []byte) bool {
+	head := data[:min(len(data), 1024)]
+	return bytes.Contains(bytes.ToUpper(head), []byte("NETSCAPE-BOOKMARK-FILE-1"))
+}
+
+func (bookmarksImporter) Parse(data []byte) ([]record, error) {
+	var records []record
+	// The names of the folders we're in; the root has no name
+	var folders []string
+	// The heading naming the folder that comes next
+	var heading string
+	var text *strings.Builder
+	var current *record
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, err
+			}
+			return records, nil
+		case html.TextToken:
+			if text != nil {
+				text.Write(z.Text())
+			}
+		case html.StartTagToken:
+			token := z.Token()
+			switch token.DataAtom {
+			case atom.H3:
+				text = &strings.Builder{}
+			case atom.Dl:
+				folders = append(folders, heading)
+				heading = ""
+			case atom.A:
+				current = &record{}
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "href":
+						current.URL = attr.Val
+					case "add_date", "time_added":
+						current.TimeAdded, _ = strconv.ParseInt(attr.Val, 10, 64)
+					case "tags":
+						current.Tags = strings.Split(attr.Val, ",")
+					}
+				}
+				text = &strings.Builder{}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.H3:
+				if text != nil {
+					heading = strings.TrimSpace(text.String())
+				}
+				text = nil
+			case atom.Dl:
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case atom.A:
+				if current == nil {
+					break
+				}
+				current.Title = strings.TrimSpace(text.String())
+				if folder, _ := normalizeTags([]string{strings.Join(folders, "/")}); len(folder) > 0 {
+					current.Tags = append(current.Tags, folder[0])
+				}
+				// Skip bookmarklets and the browser's own places
+				if strings.HasPrefix(current.URL, "http://") || strings.HasPrefix(current.URL, "https://") {
+					records = append(records, *current)
+				}
+				current = nil
+				text = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Stores articles under their canonical urls, such as after the rules for
+// canonicalizing them have changed, merging those that turn out to be the
+// same article. A run is all or nothing, and can be undone. Run as:
+// readlater canonicalize [-dry-run] [-undo run]
+func canonicalizeCommand(db library.Repo, args []string) error {
+	flags := flag.NewFlagSet("canonicalize", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "show what would be done without making changes")
+	undo := flags.Int64("undo", 0, "put back the articles merged by the run with this id")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if *undo != 0 {
+		if err := db.UndoMerge(ctx, *undo); err != nil {
+			return fmt.Errorf("failed to undo run %d: %w", *undo, err)
+		}
+		fmt.Printf("Undid run %d\n", *undo)
+		return nil
+	}
+
+	arts, err := db.StoredArticles(ctx, false, 0)
+	if err != nil {
+		return fmt.Errorf("failed to list articles: %w", err)
+	}
+
+	// Group the articles by their canonical url, keeping the order in which
+	// they were saved
+	var canonicalURLs []string
+	groups := map[string][]library.Article{}
+	var canonicalized, duplicates, skipped, errors int
+	var merges []library.Merge
+	for _, art := range arts {
+		canonicalURL, err := db.CanonicalizeURL(art.Url)
+		if err != nil {
+			log.Printf("ERROR: Failed to canonicalize URL %s: %v", art.Url, err)
+			errors++
+			continue
+		}
+		if canonicalURL != art.Url {
+			canonicalized++
+		}
+		if _, ok := groups[canonicalURL]; !ok {
+			canonicalURLs = append(canonicalURLs, canonicalURL)
+		}
+		groups[canonicalURL] = append(groups[canonicalURL], art)
+	}
+
+	for _, canonicalURL := range canonicalURLs {
+		group := groups[canonicalURL]
+		if len(group) == 1 && group[0].Url == canonicalURL {
+			continue
+		}
+		// An article still waiting to be fetched may already hold the
+		// canonical url; it is canonicalized itself once it is fetched, so
+		// leave these until then
+		if !inGroup(group, canonicalURL) {
+			if status, ok := db.ArticleStatus(ctx, canonicalURL); ok {
+				log.Printf("Skipping canonical URL %s: it is held by an article that is %s", canonicalURL, status)
+				skipped += len(group)
+				continue
+			}
+		}
+		keep := bestArticle(group)
+		var dups []string
+		for i, art := range group {
+			if i != keep {
+				dups = append(dups, art.Url)
+			}
+		}
+
+		if len(dups) == 0 {
+			log.Printf("Canonicalizing: %s -> %s", group[keep].Url, canonicalURL)
+		} else {
+			log.Printf("Found %d articles for canonical URL %s", len(group), canonicalURL)
+			log.Printf("  -> Keeping article: %s", group[keep].Url)
+			for _, dup := range dups {
+				log.Printf("  -> Merging duplicate: %s", dup)
+			}
+		}
+		duplicates += len(dups)
+		merges = append(merges, library.Merge{Keep: group[keep].Url, Duplicates: dups, Canonical: canonicalURL})
+	}
+
+	var run int64
+	if !*dryRun && len(merges) > 0 {
+		run, err = db.MergeArticles(ctx, merges)
+		if err != nil {
+			return fmt.Errorf("failed to canonicalize, nothing was changed: %w", err)
+		}
+	}
+
+	fmt.Printf("\nCanonicalization summary:\n")
+	fmt.Printf("  Articles: %d\n", len(arts))
+	fmt.Printf("  URLs canonicalized: %d\n", canonicalized)
+	fmt.Printf("  Duplicates merged: %d\n", duplicates)
+	fmt.Printf("  Skipped: %d\n", skipped)
+	fmt.Printf("  Errors: %d\n", errors)
+	if *dryRun {
+		fmt.Printf("\nThis was a dry run. Rerun without -dry-run to apply the changes.\n")
+	} else if run != 0 {
+		fmt.Printf("\nThis was run %d. To undo it: readlater canonicalize -undo %d\n", run, run)
+	}
+	return nil
+}
+
+// Picks the article to keep of those with the same canonical url: the most
+// recently saved of those that have contents
+func bestArticle(group []library.Article) int {
+	best := 0
+	for i, art := range group {
+		hasContents := strings.TrimSpace(art.Contents) != ""
+		bestHasContents := strings.TrimSpace(group[best].Contents) != ""
+		if hasContents || !bestHasContents {
+			best = i
+		}
+	}
+	return best
+}
+
+// Whether one of the articles is stored under the url
+func inGroup(group []library.Article, url string) bool {
+	for _, art := range group {
+		if art.Url == url {
+			return true
+		}
+	}
+	return false
+}
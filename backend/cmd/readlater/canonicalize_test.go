@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func TestCanonicalize(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	other := library.User("other@example.com")
+
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/a?utm_source=x", Title: "A", Contents: "old"}, "2024-01-01 00:00:00"))
+	assert.NilError(t, db.InsertWithTimestamp(ctx, other, &library.Article{Url: "https://example.com/a?ref=y", Title: "A", Contents: "new"}, "2024-01-02 00:00:00"))
+	assert.NilError(t, db.InsertPlaceholder(ctx, other, &library.Article{Url: "https://example.com/a", Title: "A"}, "2024-01-03 00:00:00", "404"))
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/b?utm_medium=z", Title: "B", Contents: "bees"}, "2024-01-04 00:00:00"))
+	_, err = db.AddTags(ctx, user, "https://example.com/a?utm_source=x", []string{"keep"})
+	assert.NilError(t, err)
+	// the user has read one copy of a and not yet another
+	assert.NilError(t, db.MarkRead(ctx, user, "https://example.com/a?utm_source=x"))
+	assert.NilError(t, db.AddToLibrary(ctx, user, "https://example.com/a"))
+	entry := func(u library.User, url string) (created string, unread bool) {
+		row := db.DB().QueryRow("SELECT created, unread FROM library WHERE user = ? AND url = ?", u, url)
+		assert.NilError(t, row.Scan(&created, &unread))
+		return created, unread
+	}
+
+	// a dry run changes nothing
+	assert.NilError(t, canonicalizeCommand(db, []string{"-dry-run"}))
+	stored, err := db.StoredArticles(ctx, false, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 4, len(stored))
+
+	assert.NilError(t, canonicalizeCommand(db, nil))
+	stored, err = db.StoredArticles(ctx, false, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(stored))
+
+	// the most recent article with contents is kept for everyone
+	for _, u := range []library.User{user, other} {
+		art, ok := db.LibraryArticle(ctx, u, "https://example.com/a")
+		assert.Assert(t, ok)
+		assert.Equal(t, "new", art.Contents)
+	}
+	_, ok := db.LibraryArticle(ctx, user, "https://example.com/b")
+	assert.Assert(t, ok)
+	tagged, err := db.Tagged(ctx, user, "keep", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(tagged))
+	assert.Equal(t, "https://example.com/a", tagged[0].Url)
+
+	// the entries of the copies are combined
+	created, unread := entry(user, "https://example.com/a")
+	assert.Equal(t, "2024-01-01T00:00:00Z", created)
+	assert.Assert(t, !unread)
+	created, unread = entry(other, "https://example.com/a")
+	assert.Equal(t, "2024-01-02T00:00:00Z", created)
+	assert.Assert(t, unread)
+
+	// undoing the run puts everything back
+	var run int64
+	assert.NilError(t, db.DB().QueryRow("SELECT max(id) FROM mergeRuns").Scan(&run))
+	assert.NilError(t, canonicalizeCommand(db, []string{"-undo", strconv.FormatInt(run, 10)}))
+	stored, err = db.StoredArticles(ctx, false, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, 4, len(stored))
+	art, ok := db.LibraryArticle(ctx, user, "https://example.com/a?utm_source=x")
+	assert.Assert(t, ok)
+	assert.Equal(t, "old", art.Contents)
+	_, unread = entry(user, "https://example.com/a")
+	assert.Assert(t, unread)
+	tagged, err = db.Tagged(ctx, user, "keep", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(tagged))
+	assert.Equal(t, "https://example.com/a?utm_source=x", tagged[0].Url)
+	_, ok = db.LibraryArticle(ctx, user, "https://example.com/b?utm_medium=z")
+	assert.Assert(t, ok)
+
+	// but only once
+	assert.ErrorContains(t, canonicalizeCommand(db, []string{"-undo", strconv.FormatInt(run, 10)}), "already been undone")
+}
+
+func TestCanonicalizePending(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/a?utm_source=x", Title: "A", Contents: "old"}, "2024-01-01 00:00:00"))
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/b?utm_source=x", Title: "B", Contents: "bees"}, "2024-01-02 00:00:00"))
+	_, err = db.Enqueue(ctx, user, "https://example.com/a", "")
+	assert.NilError(t, err)
+
+	// the article waiting to be fetched is left alone, along with those
+	// that would be stored under its url, and the rest is still done
+	assert.NilError(t, canonicalizeCommand(db, nil))
+	status, ok := db.ArticleStatus(ctx, "https://example.com/a")
+	assert.Assert(t, ok)
+	assert.Equal(t, library.StatusPending, status)
+	_, ok = db.ArticleStatus(ctx, "https://example.com/a?utm_source=x")
+	assert.Assert(t, ok)
+	_, ok = db.ArticleStatus(ctx, "https://example.com/b")
+	assert.Assert(t, ok)
+	_, ok = db.ArticleStatus(ctx, "https://example.com/b?utm_source=x")
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// The most articles packed into one EPUB
+const epubMaxArticles = 200
+
+// Limits on the images fetched for a book: how many, how big each may be
+// and how long each may take. Images past these are linked to instead.
+const (
+	epubMaxImages     = 100
+	epubMaxImageBytes = 5 << 20
+	epubImageTimeout  = 10 * time.Second
+)
+
+// The media types e-readers are sure to display, and the extensions of the
+// files holding them
+var epubImageTypes = map[string]string{
+	"image/gif":     "gif",
+	"image/jpeg":    "jpg",
+	"image/png":     "png",
+	"image/svg+xml": "svg",
+	"image/webp":    "webp",
+}
+
+type epubImage struct {
+	href      string
+	mediaType string
+}
+
+type epubChapter struct {
+	href  string
+	title string
+}
+
+// Writes articles into an EPUB as they come, one chapter each, and the
+// table of contents once they're all written
+type epubWriter struct {
+	zip      *zip.Writer
+	md       goldmark.Markdown
+	chapters []epubChapter
+	images   []epubImage
+	urls     []string
+	// Where each image already in the book is, by its source
+	embedded map[string]string
+	// How many images have been fetched
+	fetched int
+}
+
+func newEpubWriter(w io.Writer) (*epubWriter, error) {
+	e := &epubWriter{
+		zip:      zip.NewWriter(w),
+		md:       goldmark.New(goldmark.WithExtensions(extension.GFM), goldmark.WithRendererOptions(gmhtml.WithXHTML())),
+		embedded: map[string]string{},
+	}
+	// The mimetype comes first, uncompressed and with its sizes in its
+	// header rather than after it, so that readers can recognize the file
+	mimetype := []byte("application/epub+zip")
+	f, err := e.zip.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(mimetype); err != nil {
+		return nil, err
+	}
+	err = e.writeFile("META-INF/container.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`)
+	return e, err
+}
+
+func (e *epubWriter) writeFile(name string, contents string) error {
+	f, err := e.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, contents)
+	return err
+}
+
+var leadingTitle = regexp.MustCompile(`^# .*\n`)
+
+// Adds the article as the next chapter
+func (e *epubWriter) AddArticle(ctx context.Context, art *library.Article) error {
+	// The title is given its own heading
+	source := []byte(leadingTitle.ReplaceAllString(art.Contents, ""))
+	doc := e.md.Parser().Parse(text.NewReader(source))
+	if err := e.embedImages(ctx, doc, art.Url); err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := e.md.Renderer().Render(&body, source, doc); err != nil {
+		return err
+	}
+
+	href := fmt.Sprintf("article-%d.xhtml", len(e.chapters)+1)
+	var page strings.Builder
+	fmt.Fprintf(&page, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<meta charset="UTF-8"/>
+<title>%s</title>
+</head>
+<body>
+<h1>%s</h1>
+<p><a href="%s">%s</a></p>
+`, html.EscapeString(art.Title), html.EscapeString(art.Title), html.EscapeString(art.Url), html.EscapeString(art.Url))
+	if art.Summary != "" {
+		fmt.Fprintf(&page, "<blockquote><p><strong>TL;DR</strong> %s</p></blockquote>\n", html.EscapeString(art.Summary))
+	}
+	page.Write(body.Bytes())
+	page.WriteString("</body>\n</html>\n")
+	if err := e.writeFile("OEBPS/"+href, page.String()); err != nil {
+		return err
+	}
+	e.chapters = append(e.chapters, epubChapter{href, art.Title})
+	e.urls = append(e.urls, art.Url)
+	return nil
+}
+
+// Stores the article's images in the book, fetching those that aren't
+// written into the page. Readers may be offline and are often not allowed
+// to fetch anything, so images that can't be fetched become links to where
+// they are.
+func (e *epubWriter) embedImages(ctx context.Context, doc ast.Node, pageURL string) error {
+	var images []*ast.Image
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if img, ok := n.(*ast.Image); ok && entering {
+			images = append(images, img)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, img := range images {
+		src := string(img.Destination)
+		if href, ok := e.embedded[src]; ok {
+			img.Destination = []byte(href)
+			continue
+		}
+		data, mediaType, ok := localImage(src)
+		if !ok && e.fetched < epubMaxImages {
+			e.fetched++
+			data, mediaType, ok = fetchImage(ctx, pageURL, src)
+		}
+		if !ok {
+			link := ast.NewLink()
+			link.Destination = img.Destination
+			for child := img.FirstChild(); child != nil; {
+				next := child.NextSibling()
+				link.AppendChild(link, child)
+				child = next
+			}
+			img.Parent().ReplaceChild(img.Parent(), img, link)
+			continue
+		}
+		href := fmt.Sprintf("images/image-%d.%s", len(e.images)+1, epubImageTypes[mediaType])
+		f, err := e.zip.Create("OEBPS/" + href)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		e.images = append(e.images, epubImage{href, mediaType})
+		e.embedded[src] = href
+		img.Destination = []byte(href)
+	}
+	return nil
+}
+
+// Fetches an image on the page at pageURL, as long as it is of a type
+// readers display and comes within the limits on size and time
+func fetchImage(ctx context.Context, pageURL string, src string) ([]byte, string, bool) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, "", false
+	}
+	ref, err := url.Parse(src)
+	if err != nil {
+		return nil, "", false
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, epubImageTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", false
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to fetch image %s: %v", u, err)
+		return nil, "", false
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		log.Printf("Failed to fetch image %s: status %d", u, res.StatusCode)
+		return nil, "", false
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, epubMaxImageBytes+1))
+	if err != nil {
+		log.Printf("Failed to fetch image %s: %v", u, err)
+		return nil, "", false
+	}
+	if len(data) > epubMaxImageBytes {
+		log.Printf("Not embedding image %s: larger than %d bytes", u, epubMaxImageBytes)
+		return nil, "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if _, known := epubImageTypes[mediaType]; !known {
+		mediaType = http.DetectContentType(data)
+	}
+	if _, known := epubImageTypes[mediaType]; !known {
+		return nil, "", false
+	}
+	return data, mediaType, true
+}
+
+// Returns the data of an image we have without fetching anything, which
+// is so far only images written into the page as data urls
+func localImage(src string) ([]byte, string, bool) {
+	rest, ok := strings.CutPrefix(src, "data:")
+	if !ok {
+		return nil, "", false
+	}
+	header, encoded, ok := strings.Cut(rest, ",")
+	mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+	if _, known := epubImageTypes[mediaType]; !ok || !isBase64 || !known {
+		return nil, "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", false
+	}
+	return data, mediaType, true
+}
+
+// Writes the package document and tables of contents and finishes the book
+func (e *epubWriter) Close(title string) error {
+	sum := sha256.Sum256([]byte(strings.Join(e.urls, "\n")))
+	id := "urn:readlater:" + hex.EncodeToString(sum[:16])
+	title = html.EscapeString(title)
+
+	var manifest, spine, nav, ncx strings.Builder
+	for i, c := range e.chapters {
+		fmt.Fprintf(&manifest, "    <item id=\"article-%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, c.href)
+		fmt.Fprintf(&spine, "    <itemref idref=\"article-%d\"/>\n", i+1)
+		fmt.Fprintf(&nav, "      <li><a href=\"%s\">%s</a></li>\n", c.href, html.EscapeString(c.title))
+		fmt.Fprintf(&ncx, `    <navPoint id="article-%d" playOrder="%d">
+      <navLabel><text>%s</text></navLabel>
+      <content src="%s"/>
+    </navPoint>
+`, i+1, i+1, html.EscapeString(c.title), c.href)
+	}
+	for i, img := range e.images {
+		fmt.Fprintf(&manifest, "    <item id=\"image-%d\" href=\"%s\" media-type=\"%s\"/>\n", i+1, img.href, img.mediaType)
+	}
+
+	err := e.writeFile("OEBPS/content.opf", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>en</dc:language>
+    <meta property="dcterms:modified">%s</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+%s  </manifest>
+  <spine toc="ncx">
+%s  </spine>
+</package>
+`, id, title, time.Now().UTC().Format("2006-01-02T15:04:05Z"), manifest.String(), spine.String()))
+	if err != nil {
+		return err
+	}
+	err = e.writeFile("OEBPS/nav.xhtml", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<meta charset="UTF-8"/>
+<title>%s</title>
+</head>
+<body>
+  <nav epub:type="toc">
+    <h1>%s</h1>
+    <ol>
+%s    </ol>
+  </nav>
+</body>
+</html>
+`, title, title, nav.String()))
+	if err != nil {
+		return err
+	}
+	// For readers that predate EPUB 3
+	err = e.writeFile("OEBPS/toc.ncx", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="%s"/>
+  </head>
+  <docTitle><text>%s</text></docTitle>
+  <navMap>
+%s  </navMap>
+</ncx>
+`, id, title, ncx.String()))
+	if err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+// Finds the articles to put in an EPUB: the articles with the given urls,
+// the unread ones, those matching a search or those with a tag. Returns
+// the book's title along with the urls.
+func epubArticles(ctx context.Context, db library.Repo, user library.User, r *http.Request) (string, []string, error) {
+	query := r.URL.Query()
+	var list library.ArticleList
+	var title string
+	var err error
+	switch {
+	case query.Has("url"):
+		return "", query["url"], nil
+	case query.Get("unread") == "true":
+		title = "Unread articles"
+		unread := true
+		list, _, err = db.ListArticles(ctx, user, library.ListQuery{Unread: &unread, Sort: "created"})
+	case query.Has("q"):
+		title = "Articles matching " + query.Get("q")
+		list, err = db.Search(ctx, user, query.Get("q"))
+	case query.Has("tag"):
+		title = "Articles tagged " + query.Get("tag")
+		list, err = db.Tagged(ctx, user, query.Get("tag"), epubMaxArticles)
+	default:
+		return "", nil, fmt.Errorf("no articles chosen: give url, unread, q or tag")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var urls []string
+	for _, entry := range list {
+		if entry.HasBody {
+			urls = append(urls, entry.Url)
+		}
+	}
+	return title, urls, nil
+}
+
+// Sends articles as an EPUB for e-readers, one article or a collection of
+// them with a chapter each
+func exportEpub(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		ctx := r.Context()
+		title, urls, err := epubArticles(ctx, db, user, r)
+		if err != nil {
+			logError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(urls) > epubMaxArticles {
+			urls = urls[:epubMaxArticles]
+		}
+		// Look the articles up before starting, so that missing ones can
+		// still be reported. Only those asked for by url are missing; the
+		// others were just deleted since they were listed.
+		named := r.URL.Query().Has("url")
+		var articles []*library.Article
+		for _, url := range urls {
+			art, ok := db.LibraryArticle(ctx, user, url)
+			if !ok && named {
+				logError(w, fmt.Sprintf("Not in library: %s", url), http.StatusNotFound)
+				return
+			}
+			if ok {
+				articles = append(articles, art)
+			}
+		}
+		if len(articles) == 0 {
+			logError(w, "No articles to export", http.StatusNotFound)
+			return
+		}
+		if title == "" && len(articles) == 1 {
+			title = articles[0].Title
+		} else if title == "" {
+			title = "Articles"
+		}
+
+		w.Header().Set("Content-Type", "application/epub+zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="readlater.epub"`)
+		e, err := newEpubWriter(w)
+		if err == nil {
+			for _, art := range articles {
+				if err = e.AddArticle(ctx, art); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = e.Close(title)
+		}
+		// Once the book has started the status can't be changed
+		if err != nil {
+			log.Printf("Error exporting EPUB: %v", err)
+		}
+	}
+}
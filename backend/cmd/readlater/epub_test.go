@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+// A 1x1 transparent PNG
+const pixel = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+// Returns the files in the EPUB the request makes, after checking that
+// the XML in them is well formed
+func epubTest(t *testing.T, db library.Repo, user library.User, query string) (int, map[string]string) {
+	req := httptest.NewRequest(http.MethodGet, "/api/export/epub?"+query, nil)
+	w := httptest.NewRecorder()
+	exportEpub(db)(w, req, user)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	assert.Equal(t, "application/epub+zip", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	assert.NilError(t, err)
+
+	// readers recognize the book by its first file, which they read
+	// straight from the start of the book without a data descriptor
+	assert.Equal(t, "mimetype", reader.File[0].Name)
+	assert.Equal(t, zip.Store, reader.File[0].Method)
+	assert.Equal(t, uint16(0), binary.LittleEndian.Uint16(body[6:8])&0x8)
+	assert.Equal(t, "mimetypeapplication/epub+zip", string(body[30:58]))
+
+	files := map[string]string{}
+	for _, f := range reader.File {
+		r, err := f.Open()
+		assert.NilError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NilError(t, err)
+		files[f.Name] = string(data)
+		if strings.HasSuffix(f.Name, "xhtml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".ncx") {
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				_, err := decoder.Token()
+				if err == io.EOF {
+					break
+				}
+				assert.NilError(t, err, f.Name)
+			}
+		}
+	}
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	return resp.StatusCode, files
+}
+
+func TestEpub(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+
+	png, err := base64.StdEncoding.DecodeString(pixel)
+	assert.NilError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/photo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	})
+	mux.HandleFunc("/huge.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, epubMaxImageBytes+1))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{
+		Url:   "https://example.com/a",
+		Title: "Aardvarks & Anteaters",
+		Contents: "# Aardvarks & Anteaters\n\nThey eat *ants*.<br>\n\n" +
+			"![a pixel](data:image/png;base64," + pixel + ")\n\n" +
+			"![a photo](" + server.URL + "/photo)\n\n![the photo again](" + server.URL + "/photo)\n\n" +
+			"![a huge photo](" + server.URL + "/huge.png)\n\n![a lost photo](" + server.URL + "/lost.jpg)\n\n" +
+			"| Animal | Legs |\n| --- | --- |\n| Aardvark | 4 |\n",
+		Summary: "Ants get eaten.",
+	}))
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: "https://example.com/b", Title: "Baboons", Contents: "Baboons are monkeys."}))
+	assert.NilError(t, db.Insert(ctx, library.User("other@example.com"), &library.Article{Url: "https://example.com/c", Title: "Cats", Contents: "Cats."}))
+	_, err = db.AddTags(ctx, user, "https://example.com/b", []string{"primates"})
+	assert.NilError(t, err)
+
+	status, files := epubTest(t, db, user, "url=https://example.com/a")
+	assert.Equal(t, http.StatusOK, status)
+	chapter := files["OEBPS/article-1.xhtml"]
+	assert.Assert(t, strings.Contains(chapter, "<h1>Aardvarks &amp; Anteaters</h1>"))
+	assert.Equal(t, 1, strings.Count(chapter, "Anteaters</h1>"))
+	assert.Assert(t, strings.Contains(chapter, "Ants get eaten."))
+	assert.Assert(t, strings.Contains(chapter, "<em>ants</em>"))
+	assert.Assert(t, strings.Contains(chapter, "<table>"))
+	// images are embedded, once each, and those too big or that can't be
+	// fetched are linked to
+	assert.Assert(t, strings.Contains(chapter, `<img src="images/image-1.png" alt="a pixel" />`))
+	assert.Assert(t, strings.Contains(chapter, `<img src="images/image-2.png" alt="a photo" />`))
+	assert.Assert(t, strings.Contains(chapter, `<img src="images/image-2.png" alt="the photo again" />`))
+	assert.Assert(t, strings.Contains(chapter, `<a href="`+server.URL+`/huge.png">a huge photo</a>`))
+	assert.Assert(t, strings.Contains(chapter, `<a href="`+server.URL+`/lost.jpg">a lost photo</a>`))
+	assert.Assert(t, files["OEBPS/images/image-1.png"] != "")
+	assert.Equal(t, string(png), files["OEBPS/images/image-2.png"])
+	assert.Equal(t, "", files["OEBPS/images/image-3.png"])
+	assert.Assert(t, strings.Contains(files["OEBPS/content.opf"], `href="images/image-1.png" media-type="image/png"`))
+	assert.Assert(t, strings.Contains(files["OEBPS/content.opf"], "<dc:title>Aardvarks &amp; Anteaters</dc:title>"))
+	assert.Assert(t, strings.Contains(files["OEBPS/nav.xhtml"], `<a href="article-1.xhtml">Aardvarks &amp; Anteaters</a>`))
+
+	// collections have a chapter per article, and unread ones include those
+	// that were archived unread
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: "https://example.com/d", Title: "Dingoes", Contents: "Dingoes are dogs."}))
+	assert.NilError(t, db.SetArchive(ctx, user, "https://example.com/d", true))
+	status, files = epubTest(t, db, user, "unread=true")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Assert(t, strings.Contains(files["OEBPS/content.opf"], "<dc:title>Unread articles</dc:title>"))
+	assert.Assert(t, strings.Contains(files["OEBPS/article-1.xhtml"], "Dingoes are dogs."))
+	assert.Equal(t, 3, strings.Count(files["OEBPS/toc.ncx"], "<navPoint"))
+
+	status, files = epubTest(t, db, user, "tag=primates")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Assert(t, strings.Contains(files["OEBPS/article-1.xhtml"], "Baboons are monkeys."))
+	assert.Equal(t, "", files["OEBPS/article-2.xhtml"])
+
+	status, files = epubTest(t, db, user, "q=ants")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Assert(t, strings.Contains(files["OEBPS/article-1.xhtml"], "Aardvarks"))
+
+	// exporting doesn't count as reading
+	list, err := db.Recents(ctx, user, 5)
+	assert.NilError(t, err)
+	for _, entry := range list {
+		assert.Assert(t, entry.Unread)
+	}
+
+	status, _ = epubTest(t, db, user, "url=https://example.com/c")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = epubTest(t, db, user, "tag=nothing")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = epubTest(t, db, user, "")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Streams the changes to the user's library as Server-Sent Events. Each
+// changes event holds the entries that changed, as in the changes feed, and
+// has the cursor they run up to as its id. Clients resume from a cursor
+// given as Last-Event-ID, which browsers send when they reconnect, or as the
+// cursor parameter; otherwise the stream starts from now. Clients whose
+// cursor is from before deletions were forgotten get 410 Gone and have to
+// sync afresh. A comment is sent
+// every heartbeat to keep proxies from closing an idle stream.
+func streamEvents(db library.Repo, heartbeat time.Duration) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		ctx := r.Context()
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			logError(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		// Subscribe before reading the position so no change falls between
+		changed, unsubscribe := db.Subscribe(user)
+		defer unsubscribe()
+
+		cursor := r.Header.Get("Last-Event-ID")
+		if cursor == "" {
+			cursor = r.URL.Query().Get("cursor")
+		}
+		var after int64
+		if cursor != "" {
+			c, err := decodeCursor(cursor)
+			if err != nil {
+				logError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			after = c.Seq
+			err = db.CheckChangesAfter(ctx, user, after)
+			if errors.Is(err, library.ErrChangesPruned) {
+				logError(w, err.Error(), http.StatusGone)
+				return
+			}
+			if err != nil {
+				logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			var err error
+			after, err = db.ChangeSequence(ctx)
+			if err != nil {
+				logError(w, fmt.Sprintf("Error reading change sequence: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		// Tell the client where it is starting from, so it can resume from
+		// there even if nothing changes
+		fmt.Fprintf(w, "event: ready\nid: %s\ndata: {}\n\n", encodeCursor(after))
+		flusher.Flush()
+
+		// Send anything that changed while the client was away
+		pending := cursor != ""
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			if pending {
+				pending = false
+				changes, upto, err := db.GetChangesAfter(ctx, user, after)
+				if err != nil {
+					log.Printf("Error fetching article changes for %s: %v", user, err)
+					return
+				}
+				if len(changes) > 0 {
+					data, err := json.Marshal(changes)
+					if err != nil {
+						log.Printf("Error encoding article changes: %v", err)
+						return
+					}
+					fmt.Fprintf(w, "event: changes\nid: %s\ndata: %s\n\n", encodeCursor(upto), data)
+					flusher.Flush()
+				}
+				after = upto
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				pending = true
+			case <-ticker.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
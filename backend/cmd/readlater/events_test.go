@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+type sseEvent struct {
+	Event string
+	Id    string
+	Data  string
+}
+
+// Reads events from a stream, skipping comments
+func readEvents(t *testing.T, scanner *bufio.Scanner, events chan<- sseEvent) {
+	var ev sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if ev.Event != "" {
+				events <- ev
+			}
+			ev = sseEvent{}
+		case strings.HasPrefix(line, ":"):
+			events <- sseEvent{Event: "comment"}
+		default:
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "event":
+				ev.Event = value
+			case "id":
+				ev.Id = value
+			case "data":
+				ev.Data = value
+			}
+		}
+	}
+	close(events)
+}
+
+func openEvents(t *testing.T, server *httptest.Server, lastEventId string) (<-chan sseEvent, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NilError(t, err)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := make(chan sseEvent, 16)
+	go readEvents(t, bufio.NewScanner(resp.Body), events)
+	return events, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+// Waits for the next event that isn't a heartbeat
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			assert.Assert(t, ok, "stream closed")
+			if ev.Event != "comment" {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("no event")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	other := library.User("other@example.com")
+	a := "https://example.com/a"
+	b := "https://example.com/b"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(db, 20*time.Millisecond)(w, r, user)
+	}))
+	defer server.Close()
+
+	events, closeEvents := openEvents(t, server, "")
+	ready := nextEvent(t, events)
+	assert.Equal(t, "ready", ready.Event)
+
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: a, Title: "A", Contents: "a"}))
+	ev := nextEvent(t, events)
+	assert.Equal(t, "changes", ev.Event)
+	var changes library.ArticleList
+	assert.NilError(t, json.Unmarshal([]byte(ev.Data), &changes))
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, a, changes[0].Url)
+
+	// other users' changes aren't sent
+	assert.NilError(t, db.Insert(ctx, other, &library.Article{Url: b, Title: "B", Contents: "b"}))
+	assert.NilError(t, db.SetArchive(ctx, user, a, true))
+	ev = nextEvent(t, events)
+	assert.NilError(t, json.Unmarshal([]byte(ev.Data), &changes))
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, a, changes[0].Url)
+	assert.Assert(t, changes[0].Archived)
+
+	// heartbeats keep coming
+	timeout := time.After(5 * time.Second)
+	for heartbeat := false; !heartbeat; {
+		select {
+		case ev := <-events:
+			heartbeat = ev.Event == "comment"
+		case <-timeout:
+			t.Fatal("no heartbeat")
+		}
+	}
+	closeEvents()
+
+	// changes made while disconnected are sent on resume
+	assert.NilError(t, db.MarkRead(ctx, user, a))
+	assert.NilError(t, db.AddToLibrary(ctx, user, b))
+	events, closeEvents = openEvents(t, server, ev.Id)
+	defer closeEvents()
+	assert.Equal(t, "ready", nextEvent(t, events).Event)
+	ev = nextEvent(t, events)
+	assert.Equal(t, "changes", ev.Event)
+	assert.NilError(t, json.Unmarshal([]byte(ev.Data), &changes))
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, a, changes[0].Url)
+	assert.Assert(t, !changes[0].Unread)
+	assert.Equal(t, b, changes[1].Url)
+}
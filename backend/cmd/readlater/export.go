@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Writes articles in one of the export formats
+type exporter interface {
+	Write(art library.ExportedArticle) error
+	// Finishes the export once every article has been written
+	Close() error
+}
+
+type exportFormat struct {
+	contentType string
+	extension   string
+	new         func(w io.Writer) (exporter, error)
+}
+
+// jsonl has everything about each article, csv is what Pocket exports and
+// the importer reads, and html is a bookmarks file that browsers import.
+// Only jsonl has the articles in the trash, since the others have no way
+// to mark them and importing them would take them out of the trash.
+var exportFormats = map[string]exportFormat{
+	"jsonl": {"application/jsonl", "jsonl", newJsonlExporter},
+	"csv":   {"text/csv", "csv", newPocketExporter},
+	"html":  {"text/html", "html", newBookmarksExporter},
+}
+
+func exportFormatNames() []string {
+	var names []string
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+type jsonlExporter struct {
+	enc *json.Encoder
+}
+
+func newJsonlExporter(w io.Writer) (exporter, error) {
+	return jsonlExporter{json.NewEncoder(w)}, nil
+}
+
+func (e jsonlExporter) Write(art library.ExportedArticle) error {
+	return e.enc.Encode(art)
+}
+
+func (e jsonlExporter) Close() error {
+	return nil
+}
+
+type pocketExporter struct {
+	w *csv.Writer
+}
+
+func newPocketExporter(w io.Writer) (exporter, error) {
+	e := pocketExporter{csv.NewWriter(w)}
+	return e, e.w.Write([]string{"title", "url", "time_added", "tags", "status"})
+}
+
+func (e pocketExporter) Write(art library.ExportedArticle) error {
+	if art.Trashed != nil {
+		return nil
+	}
+	status := "unread"
+	if art.Archived {
+		status = "archive"
+	}
+	return e.w.Write([]string{
+		art.Title, art.Url, strconv.FormatInt(art.Added.Unix(), 10), strings.Join(art.Tags, "|"), status,
+	})
+}
+
+func (e pocketExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type bookmarksExporter struct {
+	w io.Writer
+}
+
+func newBookmarksExporter(w io.Writer) (exporter, error) {
+	_, err := io.WriteString(w, `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+	return bookmarksExporter{w}, err
+}
+
+func (e bookmarksExporter) Write(art library.ExportedArticle) error {
+	if art.Trashed != nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(e.w, "    <DT><A HREF=\"%s\" ADD_DATE=\"%d\" TAGS=\"%s\">%s</A>\n",
+		html.EscapeString(art.Url), art.Added.Unix(), html.EscapeString(strings.Join(art.Tags, ",")),
+		html.EscapeString(art.Title))
+	return err
+}
+
+func (e bookmarksExporter) Close() error {
+	_, err := io.WriteString(e.w, "</DL><p>\n")
+	return err
+}
+
+// Writes every article in the user's library in the format
+func exportLibrary(ctx context.Context, db library.Repo, user library.User, format exportFormat, w io.Writer) error {
+	e, err := format.new(w)
+	if err != nil {
+		return err
+	}
+	if err := db.ExportArticles(ctx, user, e.Write); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// Streams the user's library to them as a download
+func exportArticles(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			name = "jsonl"
+		}
+		format, ok := exportFormats[name]
+		if !ok {
+			logError(w, fmt.Sprintf("Invalid format: %s", name), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="readlater.%s"`, format.extension))
+		// Once the export has started the status can't be changed
+		if err := exportLibrary(r.Context(), db, user, format, w); err != nil {
+			log.Printf("Error exporting articles: %v", err)
+		}
+	}
+}
+
+func exportCommand(db library.Repo, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	user := flags.String("user", "", "user whose library is exported")
+	name := flags.String("format", "jsonl", "export format: "+strings.Join(exportFormatNames(), ", "))
+	output := flags.String("o", "", "file to write, instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, ok := exportFormats[*name]
+	if !ok {
+		return fmt.Errorf("invalid format: %s", *name)
+	}
+	if *output == "" {
+		return exportLibrary(context.Background(), db, library.User(*user), format, os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := exportLibrary(context.Background(), db, library.User(*user), format, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
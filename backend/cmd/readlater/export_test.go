@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func exportTest(t *testing.T, db library.Repo, user library.User, format string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/api/export?format="+format, nil)
+	w := httptest.NewRecorder()
+	exportArticles(db)(w, req, user)
+	return w.Result()
+}
+
+func TestExport(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+
+	// enough articles to take more than one page
+	count := library.ExportPageSize + 1
+	for i := range count {
+		art := &library.Article{Url: fmt.Sprintf("https://example.com/%d", i), Title: fmt.Sprintf("Article <%d>", i), Contents: "words"}
+		created := fmt.Sprintf("2024-01-01 00:%02d:%02d", i/60, i%60)
+		assert.NilError(t, db.InsertWithTimestamp(ctx, user, art, created))
+	}
+	_, err = db.AddTags(ctx, user, "https://example.com/0", []string{"work/projects", "long reads"})
+	assert.NilError(t, err)
+	assert.NilError(t, db.SetArchive(ctx, user, "https://example.com/0", true))
+	assert.NilError(t, db.AddToLibrary(ctx, library.User("other@example.com"), "https://example.com/1"))
+	_, err = db.Trash(ctx, user, "https://example.com/2")
+	assert.NilError(t, err)
+	assert.NilError(t, db.AddAliases(ctx, "https://example.com/0", []string{"https://example.com/zero"}))
+	_, err = db.ApplyMutations(ctx, user, "phone", []library.Mutation{{Url: "https://example.com/1", Field: "unread", Value: false, Timestamp: 1700000000000}})
+	assert.NilError(t, err)
+
+	resp := exportTest(t, db, user, "jsonl")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `attachment; filename="readlater.jsonl"`, resp.Header.Get("Content-Disposition"))
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []library.ExportedArticle
+	for scanner.Scan() {
+		var art library.ExportedArticle
+		assert.NilError(t, json.Unmarshal(scanner.Bytes(), &art))
+		lines = append(lines, art)
+	}
+	assert.Equal(t, count, len(lines))
+	assert.Equal(t, "https://example.com/0", lines[0].Url)
+	assert.Equal(t, "words", lines[0].Contents)
+	assert.Assert(t, lines[0].Archived && lines[0].Unread)
+	assert.DeepEqual(t, []string{"long reads", "work/projects"}, lines[0].Tags)
+	assert.Equal(t, "2024-01-01 00:00:00", lines[0].Added.UTC().Format("2006-01-02 15:04:05"))
+	art, ok := db.LibraryArticle(ctx, user, "https://example.com/0")
+	assert.Assert(t, ok)
+	assert.Equal(t, art.Id, lines[0].Id)
+	assert.DeepEqual(t, []string{"https://example.com/zero"}, lines[0].Aliases)
+	assert.Equal(t, int64(1700000000000), lines[1].UnreadChanged)
+	assert.Equal(t, "phone", lines[1].UnreadDevice)
+	assert.Assert(t, !lines[1].Unread)
+	assert.Equal(t, fmt.Sprintf("https://example.com/%d", count-1), lines[count-1].Url)
+	// the trash is exported too, marked as such
+	assert.Assert(t, lines[0].Trashed == nil)
+	assert.Equal(t, "https://example.com/2", lines[2].Url)
+	assert.Assert(t, lines[2].Trashed != nil)
+
+	// the CSV is laid out the way Pocket's is
+	resp = exportTest(t, db, user, "csv")
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	assert.NilError(t, err)
+	assert.Equal(t, count, len(rows))
+	assert.DeepEqual(t, []string{"title", "url", "time_added", "tags", "status"}, rows[0])
+	assert.DeepEqual(t, []string{"Article <0>", "https://example.com/0", "1704067200", "long reads|work/projects", "archive"}, rows[1])
+	assert.Equal(t, "unread", rows[2][4])
+	assert.Equal(t, "https://example.com/3", rows[3][1])
+
+	resp = exportTest(t, db, user, "html")
+	var body strings.Builder
+	_, err = bufio.NewReader(resp.Body).WriteTo(&body)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(body.String(), "<!DOCTYPE NETSCAPE-Bookmark-file-1>"))
+	assert.Assert(t, strings.Contains(body.String(),
+		`<DT><A HREF="https://example.com/0" ADD_DATE="1704067200" TAGS="long reads,work/projects">Article &lt;0&gt;</A>`))
+
+	// only the user's own library is exported
+	resp = exportTest(t, db, library.User("other@example.com"), "csv")
+	rows, err = csv.NewReader(resp.Body).ReadAll()
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(rows))
+
+	assert.Equal(t, http.StatusBadRequest, exportTest(t, db, user, "pdf").StatusCode)
+}
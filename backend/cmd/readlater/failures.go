@@ -0,0 +1,31 @@
+package main
+
+import "encoding/json"
+
+// Reads the failure report from an earlier import, to try the records that
+// failed again
+type failuresImporter struct{}
+
+func (failuresImporter) Name() string { return "failures" }
+
+func (failuresImporter) Detect(data []byte) bool {
+	return jsonArrayHas(data, "error")
+}
+
+func (failuresImporter) Parse(data []byte) ([]record, error) {
+	var failures []failure
+	if err := json.Unmarshal(data, &failures); err != nil {
+		return nil, err
+	}
+	var records []record
+	for _, f := range failures {
+		records = append(records, record{
+			Title:     f.Title,
+			URL:       f.URL,
+			TimeAdded: f.TimeAdded,
+			Tags:      f.Tags,
+			Archived:  f.Archived,
+		})
+	}
+	return records, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"github.com/rcbilson/readlater/www"
+)
+
+type httpError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func handler(summarizer library.SummarizeFunc, db library.Repo, fetcher www.FetcherFunc, queue *library.IngestQueue, prices priceTable, changesPageBytes int, eventHeartbeat time.Duration, port int, frontendPath string, auth *authenticator, admins []string) {
+	authHandler := noAuth()
+	if auth != nil {
+		authHandler = auth.middleware()
+		http.Handle("POST /api/login", auth.login())
+		http.Handle("POST /api/logout", auth.logout())
+	}
+	// Handle the api routes in the backend
+	http.Handle("POST /api/summarize", authHandler(requireScope(scopeAdd, summarize(summarizer, db, fetcher, queue))))
+	http.Handle("POST /api/markRead", authHandler(requireScope(scopeWrite, markRead(db))))
+	http.Handle("GET /api/recents", authHandler(requireScope(scopeRead, fetchRecents(db))))
+	http.Handle("GET /api/archive", authHandler(requireScope(scopeRead, fetchArchive(db))))
+	http.Handle("GET /api/search", authHandler(requireScope(scopeRead, search(db))))
+	http.Handle("PUT /api/setArchive", authHandler(requireScope(scopeWrite, setArchive(db))))
+	http.Handle("GET /api/articles", authHandler(requireScope(scopeRead, listArticles(db))))
+	http.Handle("GET /api/articles/{id}", authHandler(requireScope(scopeRead, getArticle(db))))
+	http.Handle("PATCH /api/articles/{id}", authHandler(requireScope(scopeWrite, patchArticle(db))))
+	http.Handle("DELETE /api/articles/{id}", authHandler(requireScope(scopeWrite, trashArticle(db, articleFromPath))))
+	http.Handle("POST /api/articles/{id}/restore", authHandler(requireScope(scopeWrite, restoreArticle(db, articleFromPath))))
+	http.Handle("DELETE /api/article", authHandler(requireScope(scopeWrite, trashArticle(db, articleFromQuery))))
+	http.Handle("POST /api/article/restore", authHandler(requireScope(scopeWrite, restoreArticle(db, articleFromQuery))))
+	http.Handle("GET /api/trash", authHandler(requireScope(scopeRead, fetchTrash(db))))
+	http.Handle("GET /api/tags", authHandler(requireScope(scopeRead, listTags(db))))
+	http.Handle("GET /api/tagged", authHandler(requireScope(scopeRead, fetchTagged(db))))
+	http.Handle("POST /api/addTags", authHandler(requireScope(scopeWrite, addTags(db))))
+	http.Handle("POST /api/removeTags", authHandler(requireScope(scopeWrite, removeTags(db))))
+	http.Handle("GET /api/changes", authHandler(requireScope(scopeRead, fetchChanges(db, changesPageBytes))))
+	http.Handle("GET /api/events", authHandler(requireScope(scopeRead, streamEvents(db, eventHeartbeat))))
+	http.Handle("POST /api/sync", authHandler(requireScope(scopeRead, requireScope(scopeWrite, syncChanges(db)))))
+	http.Handle("GET /api/export", authHandler(requireScope(scopeRead, exportArticles(db))))
+	http.Handle("GET /api/export/epub", authHandler(requireScope(scopeRead, exportEpub(db))))
+	http.Handle("GET /api/usage", authHandler(requireScope(scopeRead, requireAdmin(admins, fetchUsage(db, prices)))))
+	http.Handle("POST /api/tokens", authHandler(requireSession(createToken(db))))
+	http.Handle("GET /api/tokens", authHandler(requireSession(listTokens(db))))
+	http.Handle("DELETE /api/tokens/{id}", authHandler(requireSession(revokeToken(db))))
+	// frontend
+	http.Handle("GET /", http.FileServer(http.Dir(frontendPath)))
+	log.Println("server listening on port", port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+}
+
+func logError(w http.ResponseWriter, msg string, code int) {
+	log.Printf("%d %s", code, msg)
+	http.Error(w, msg, code)
+}
+
+func search(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		query, ok := r.URL.Query()["q"]
+		if !ok {
+			logError(w, "No search terms provided", http.StatusBadRequest)
+			return
+		}
+		list, err := db.Search(r.Context(), user, query[0])
+		if err != nil {
+			logError(w, fmt.Sprintf("Error searching articles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+		w.Header().Set("Content-Type", "application/json")
+	}
+}
+
+func fetchRecents(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		var err error
+		count := 5
+		countStr, ok := r.URL.Query()["count"]
+		if ok {
+			count, err = strconv.Atoi(countStr[0])
+			if err != nil {
+				logError(w, fmt.Sprintf("Invalid count specification: %s", countStr[0]), http.StatusBadRequest)
+				return
+			}
+		}
+		recentList, err := db.Recents(r.Context(), user, count)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error fetching recent articles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(recentList)
+		w.Header().Set("Content-Type", "application/json")
+	}
+}
+
+func setArchive(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		var err error
+		archived := false
+		archiveStr, ok := r.URL.Query()["setArchive"]
+		if ok {
+			if archiveStr[0] == "true" {
+				archived = true
+			}
+		}
+		var url string
+		urls, ok := r.URL.Query()["url"]
+		if ok {
+			url = urls[0]
+		} else {
+			logError(w, "No URL provided", http.StatusBadRequest)
+			return
+		}
+		err = db.SetArchive(r.Context(), user, url, archived)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error setting archive status: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func fetchArchive(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		var err error
+		count := 5
+		countStr, ok := r.URL.Query()["count"]
+		if ok {
+			count, err = strconv.Atoi(countStr[0])
+			if err != nil {
+				logError(w, fmt.Sprintf("Invalid count specification: %s", countStr[0]), http.StatusBadRequest)
+				return
+			}
+		}
+		recentList, err := db.Archive(r.Context(), user, count)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error fetching favorite articles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(recentList)
+		w.Header().Set("Content-Type", "application/json")
+	}
+}
+
+// Adds an article to the user's library, fetching it if we don't already
+// have it. If the request asks for it and a queue is available the fetch
+// happens in the background and the pending article is returned at once.
+func summarize(summarizer library.SummarizeFunc, db library.Repo, fetcher www.FetcherFunc, queue *library.IngestQueue) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		ctx := r.Context()
+
+		var req struct {
+			Url       string `json:"url"`
+			TitleHint string `json:"titleHint"`
+			Async     bool   `json:"async"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+			return
+		}
+		_, err = url.Parse(req.Url)
+		if err != nil {
+			logError(w, fmt.Sprintf("Invalid URL: %v", err), http.StatusBadRequest)
+			return
+		}
+		status := http.StatusOK
+		// Any url the article has been seen under finds it
+		article, ok := db.GetWithoutUpdating(ctx, req.Url)
+		if ok && article.Status == library.StatusFailed && queue != nil {
+			// Give articles that failed to fetch another chance
+			article, err = queue.Retry(ctx, user, req.Url, req.TitleHint)
+			if err != nil {
+				logError(w, fmt.Sprintf("Error queueing article: %v", err), http.StatusInternalServerError)
+				return
+			}
+			status = http.StatusAccepted
+		} else if !ok && req.Async && queue != nil {
+			article, err = queue.Enqueue(ctx, user, req.Url, req.TitleHint)
+			if err != nil {
+				logError(w, fmt.Sprintf("Error queueing article: %v", err), http.StatusInternalServerError)
+				return
+			}
+			status = http.StatusAccepted
+		} else if !ok {
+			article, ok, err = library.FetchArticle(ctx, db, summarizer, fetcher, req.Url, req.TitleHint)
+			if errors.Is(err, library.ErrFetchFailed) {
+				logError(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				logError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				err = db.Insert(ctx, user, article)
+				if err != nil {
+					log.Printf("Error inserting into db: %v", err)
+				}
+			}
+		}
+		// The contents may have been fetched for another user
+		if ok {
+			err = db.AddToLibrary(ctx, user, article.Url)
+			if err != nil {
+				log.Printf("Error adding to library: %v", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(article)
+	}
+}
+
+// Reports the articles in the user's library that have changed since the
+// client last asked, and those that have been removed from it. Removals are
+// only remembered for the tombstone horizon, so clients that last asked
+// before removals were forgotten get 410 Gone and have to start again with
+// an empty cursor.
+//
+// Clients pass back the cursor from the previous response, or an empty one
+// to get everything, and receive the changes along with the next cursor.
+// With include=contents the articles' contents come too, in pages of about
+// pageBytes, and more is set until the client has caught up. Older clients
+// pass the time they last asked as since and receive just the changes.
+func fetchChanges(db library.Repo, pageBytes int) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		query := r.URL.Query()
+		if query.Has("cursor") {
+			after, err := decodeCursor(query.Get("cursor"))
+			if err != nil {
+				logError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var response struct {
+				Changes library.ArticleList `json:"changes"`
+				Cursor  string              `json:"cursor"`
+				More    bool                `json:"more"`
+			}
+			if query.Get("include") == "contents" {
+				maxBytes := pageBytes
+				if maxStr := query.Get("maxBytes"); maxStr != "" {
+					maxBytes, err = strconv.Atoi(maxStr)
+					if err != nil || maxBytes <= 0 {
+						logError(w, fmt.Sprintf("Invalid maxBytes: %s", maxStr), http.StatusBadRequest)
+						return
+					}
+					maxBytes = min(maxBytes, pageBytes)
+				}
+				var next int64
+				response.Changes, next, response.More, err = db.GetContentChangesAfter(r.Context(), user, after.Seq, after.Base, maxBytes)
+				if response.More {
+					response.Cursor = encodePageCursor(next, after.Base)
+				} else {
+					response.Cursor = encodeCursor(next)
+				}
+			} else {
+				var upto int64
+				response.Changes, upto, err = db.GetChangesAfter(r.Context(), user, after.Seq)
+				response.Cursor = encodeCursor(upto)
+			}
+			if errors.Is(err, library.ErrChangesPruned) {
+				logError(w, err.Error(), http.StatusGone)
+				return
+			}
+			if err != nil {
+				logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if response.Changes == nil {
+				response.Changes = library.ArticleList{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		since := query.Get("since")
+		if since == "" {
+			// If no timestamp provided, return empty list
+			json.NewEncoder(w).Encode(library.ArticleList{})
+			w.Header().Set("Content-Type", "application/json")
+			return
+		}
+
+		changesList, err := db.GetChangesSince(r.Context(), user, since)
+		if errors.Is(err, library.ErrChangesPruned) {
+			logError(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(changesList)
+		w.Header().Set("Content-Type", "application/json")
+	}
+}
+
+func markRead(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		ctx := r.Context()
+
+		var req struct {
+			Url string `json:"url"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = db.MarkRead(ctx, user, req.Url)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error marking article as read: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/rcbilson/readlater/internal/library"
 	"gotest.tools/assert"
 )
 
@@ -36,14 +37,14 @@ type articleListEntryStruct struct {
 
 type articleListStruct []articleListEntryStruct
 
-func mockSummarizer(_ context.Context, article []byte) (string, error) {
+func mockSummarizer(_ context.Context, _ string, article []byte) (library.Summary, error) {
 	// split the article into words and use each word as an ingredient
 	// this allows us to search for something non-trivial
-	return "# summary for " + string(article) + "\n" +
-		strings.Join(strings.Split(string(article), ":/? "), " "), nil
+	return library.Summary{Contents: "# summary for " + string(article) + "\n" +
+		strings.Join(strings.Split(string(article), ":/? "), " ")}, nil
 }
 
-func summarizeTest(t *testing.T, db Repo, url string) {
+func summarizeTest(t *testing.T, db library.Repo, url string) {
 	var reqData struct {
 		Url string `json:"url"`
 	}
@@ -52,7 +53,7 @@ func summarizeTest(t *testing.T, db Repo, url string) {
 	assert.NilError(t, err)
 	req := httptest.NewRequest(http.MethodPost, "/summarize", bytes.NewReader(data))
 	w := httptest.NewRecorder()
-	summarize(mockSummarizer, db, mockFetcher)(w, req, User("test@example.com"))
+	summarize(mockSummarizer, db, mockFetcher, nil)(w, req, library.User("test@example.com"))
 	resp := w.Result()
 	defer resp.Body.Close()
 
@@ -65,7 +66,7 @@ func summarizeTest(t *testing.T, db Repo, url string) {
 func listTest(t *testing.T, handler AuthHandlerFunc, reqName string, reqCount int, expCount int, resultList *articleListStruct) {
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s?count=%d", reqName, reqCount), nil)
 	w := httptest.NewRecorder()
-	handler(w, req, User("test@example.com"))
+	handler(w, req, library.User("test@example.com"))
 	resp := w.Result()
 	defer resp.Body.Close()
 
@@ -78,10 +79,10 @@ func listTest(t *testing.T, handler AuthHandlerFunc, reqName string, reqCount in
 	}
 }
 
-func searchTest(t *testing.T, db Repo, pattern string, expCount int) {
+func searchTest(t *testing.T, db library.Repo, pattern string, expCount int) {
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/search?q=%s", url.QueryEscape(pattern)), nil)
 	w := httptest.NewRecorder()
-	search(db)(w, req, User("test@example.com"))
+	search(db)(w, req, library.User("test@example.com"))
 	resp := w.Result()
 	defer resp.Body.Close()
 
@@ -91,14 +92,14 @@ func searchTest(t *testing.T, db Repo, pattern string, expCount int) {
 	assert.Equal(t, expCount, len(articleList))
 }
 
-func setArchiveTest(t *testing.T, db Repo, url string, archived bool) {
+func setArchiveTest(t *testing.T, db library.Repo, url string, archived bool) {
 	archivedStr := "false"
 	if archived {
 		archivedStr = "true"
 	}
 	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/setArchive?url=%s&setArchive=%s", url, archivedStr), nil)
 	w := httptest.NewRecorder()
-	setArchive(db)(w, req, User("test@example.com"))
+	setArchive(db)(w, req, library.User("test@example.com"))
 	resp := w.Result()
 	defer resp.Body.Close()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
@@ -106,7 +107,7 @@ func setArchiveTest(t *testing.T, db Repo, url string, archived bool) {
 
 // TODO: test something other than the happy path
 func TestHandlers(t *testing.T) {
-	db, err := NewTestRepo()
+	db, err := library.NewTestRepo()
 	assert.NilError(t, err)
 
 	// basic summarize request
@@ -131,8 +132,8 @@ func TestHandlers(t *testing.T) {
 	// ask for one archived, expect one
 	listTest(t, fetchArchive(db), "archive", 1, 1, nil)
 
-	// ask for five archived, expect three
-	listTest(t, fetchArchive(db), "archive", 5, 3, nil)
+	// ask for five archived, expect one
+	listTest(t, fetchArchive(db), "archive", 5, 1, nil)
 
 	// should have one search hit
 	searchTest(t, db, "buttermilk", 1)
@@ -146,3 +147,73 @@ func TestHandlers(t *testing.T) {
 	// should have no search hits
 	searchTest(t, db, "foo", 0)
 }
+
+func TestPerUserLibraries(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	alice := library.User("alice@example.com")
+	bob := library.User("bob@example.com")
+
+	fetches := 0
+	countingFetcher := func(ctx context.Context, url string) ([]byte, string, error) {
+		fetches++
+		return mockFetcher(ctx, url)
+	}
+	// returns the url the article is stored under
+	add := func(user library.User, url string) string {
+		data, err := json.Marshal(map[string]string{"url": url})
+		assert.NilError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/summarize", bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		summarize(mockSummarizer, db, countingFetcher, nil)(w, req, user)
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		var art library.Article
+		assert.NilError(t, json.NewDecoder(w.Result().Body).Decode(&art))
+		return art.Url
+	}
+
+	first := add(alice, urls[0])
+	second := add(alice, urls[1])
+	assert.Equal(t, first, add(bob, urls[0]))
+	// contents are shared, so bob's request didn't fetch again
+	assert.Equal(t, 2, fetches)
+
+	list, err := db.Recents(ctx, alice, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(list))
+	list, err = db.Recents(ctx, bob, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	// state is per user
+	assert.NilError(t, db.MarkRead(ctx, alice, first))
+	assert.NilError(t, db.SetArchive(ctx, bob, first, true))
+	list, _, err = db.ListArticles(ctx, alice, library.ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(list))
+	for _, entry := range list {
+		assert.Assert(t, !entry.Archived)
+		if entry.Url == first {
+			assert.Assert(t, !entry.Unread)
+		}
+	}
+	list, err = db.Archive(ctx, bob, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Archived)
+	assert.Assert(t, list[0].Unread)
+
+	// search only covers the user's own library
+	list, err = db.Search(ctx, alice, "seriouseats")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	list, err = db.Search(ctx, bob, "seriouseats")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	_, ok := db.Get(ctx, bob, second)
+	assert.Assert(t, !ok)
+	_, ok = db.Get(ctx, alice, second)
+	assert.Assert(t, ok)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const googleJwksUrl = "https://www.googleapis.com/oauth2/v3/certs"
+
+// How long a downloaded key set is trusted before it is fetched again, and
+// the minimum interval between fetches triggered by an unknown key id.
+const jwksMaxAge = time.Hour
+const jwksMinRefresh = time.Minute
+
+// Tolerance for clock differences between us and the token issuer
+const idTokenLeeway = time.Minute
+
+type idTokenClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	IssuedAt      int64  `json:"iat"`
+	Expires       int64  `json:"exp"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// idTokenVerifier checks the signature and claims of Google ID tokens. The
+// signing keys come from a JWKS document that is either read from a file
+// or downloaded from a URL, which allows tests to run without Google.
+type idTokenVerifier struct {
+	clientId string
+	loadKeys func(ctx context.Context) ([]byte, error)
+	now      func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newIdTokenVerifier(clientId string, jwksFile string, jwksUrl string) *idTokenVerifier {
+	v := &idTokenVerifier{clientId: clientId, now: time.Now}
+	if jwksFile != "" {
+		v.loadKeys = func(_ context.Context) ([]byte, error) {
+			return os.ReadFile(jwksFile)
+		}
+	} else {
+		if jwksUrl == "" {
+			jwksUrl = googleJwksUrl
+		}
+		v.loadKeys = func(ctx context.Context) ([]byte, error) {
+			return fetchJwks(ctx, jwksUrl)
+		}
+	}
+	return v
+}
+
+func fetchJwks(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", url, res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func parseJwks(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %s: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %s: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no RSA keys")
+	}
+	return keys, nil
+}
+
+// Returns the key with the given id, reloading the key set if it is stale or
+// does not contain the key.
+func (v *idTokenVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	age := v.now().Sub(v.fetched)
+	key, ok := v.keys[kid]
+	if ok && age < jwksMaxAge {
+		return key, nil
+	}
+	if v.keys == nil || age >= jwksMaxAge || (!ok && age >= jwksMinRefresh) {
+		data, err := v.loadKeys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing keys: %w", err)
+		}
+		keys, err := parseJwks(data)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+		v.fetched = v.now()
+		key, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// Verify checks that token is a correctly signed, unexpired ID token issued
+// by Google for our client id and returns its claims.
+func (v *idTokenVerifier) Verify(ctx context.Context, token string) (*idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("bad token signature")
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com" {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if claims.Audience != v.clientId {
+		return nil, fmt.Errorf("token issued for another client: %q", claims.Audience)
+	}
+	now := v.now()
+	if now.After(time.Unix(claims.Expires, 0).Add(idTokenLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if now.Add(idTokenLeeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, errors.New("token issued in the future")
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("token has no verified email")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"github.com/rcbilson/readlater/www"
+)
+
+// Imports an export from another service into a user's library. Run as:
+// readlater import -file export.csv -user you@example.com [-format auto]
+func importCommand(db library.Repo, summarizer library.SummarizeFunc, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("file", "", "export to import")
+	flags.StringVar(file, "csv", "", "same as -file, for compatibility")
+	format := flags.String("format", "auto", "format of the export: auto, "+importerNames())
+	dryRun := flags.Bool("dry-run", false, "preview the import without making changes")
+	user := flags.String("user", "", "user whose library receives the articles")
+	opts := batchFlags(flags)
+	flags.StringVar(&opts.Checkpoint, "checkpoint", "", "file recording the records done, so that a rerun resumes (default <file>.progress)")
+	flags.StringVar(&opts.Failures, "failures", "", "file to write the records that failed to, which can be imported to retry them (default <file>.failures.json)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("the export to import is required (-file)")
+	}
+	if strings.TrimSpace(*user) == "" {
+		return fmt.Errorf("the user to import for is required (-user)")
+	}
+	if opts.Checkpoint == "" {
+		opts.Checkpoint = *file + ".progress"
+	}
+	if opts.Failures == "" {
+		opts.Failures = *file + ".failures.json"
+	}
+
+	fmt.Printf("Import configuration:\n")
+	fmt.Printf("  File: %s\n", *file)
+	fmt.Printf("  User: %s\n", *user)
+	fmt.Printf("  Dry run: %v\n", *dryRun)
+	fmt.Println()
+
+	// Parse the export
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	imp, err := findImporter(*format, data)
+	if err != nil {
+		return err
+	}
+	records, err := imp.Parse(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s export: %w", imp.Name(), err)
+	}
+
+	fmt.Printf("Found %d records in %s export\n", len(records), imp.Name())
+
+	if *dryRun {
+		fmt.Println("\nDry run mode - showing first 5 records:")
+		for i, record := range records {
+			if i >= 5 {
+				break
+			}
+			tags, _ := normalizeTags(record.Tags)
+			fmt.Printf("  %d. URL: %s, Time: %s, Title: %s, Tags: %s, Archived: %v\n",
+				i+1, record.URL, record.created().Format("2006-01-02 15:04:05"), record.Title,
+				strings.Join(tags, ", "), record.Archived)
+		}
+		return nil
+	}
+
+	return processRecords(records, db, library.User(*user), www.FetcherCombined, summarizer, *opts)
+}
+
+// Returns the url under which the user's library holds the article, which
+// may be another url it is known by
+func libraryURL(ctx context.Context, db library.Repo, user library.User, rawURL string) (string, bool) {
+	art, ok := db.LibraryArticle(ctx, user, rawURL)
+	if !ok {
+		return "", false
+	}
+	return art.Url, true
+}
+
+// Bring the tags and archive status across to an article in the library,
+// which may have been imported before they were
+func organizeRecord(ctx context.Context, record record, db library.Repo, user library.User, url string) error {
+	tags, err := normalizeTags(record.Tags)
+	if err != nil {
+		return err
+	}
+	if _, err := db.AddTags(ctx, user, url, tags); err != nil {
+		return fmt.Errorf("failed to tag: %w", err)
+	}
+	if record.Archived {
+		if err := db.SetArchive(ctx, user, url, true); err != nil {
+			return fmt.Errorf("failed to archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// Adds the record's article to the user's library, through the same
+// pipeline as articles saved in the app
+func processRecord(ctx context.Context, record record, db library.Repo, user library.User, fetcher www.FetcherFunc, summarizer library.SummarizeFunc) error {
+	// Validate URL
+	if _, err := url.Parse(record.URL); err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	// Fetch content, unless the export has it
+	var art *library.Article
+	var err error
+	if record.Html != nil {
+		art, _, err = library.ConvertArticle(ctx, db, summarizer, record.URL, record.URL, record.Html, record.Title)
+	} else {
+		art, _, err = library.FetchArticle(ctx, db, summarizer, fetcher, record.URL, record.Title)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Convert timestamp to SQLite datetime format
+	createdTime := record.created().UTC().Format("2006-01-02 15:04:05")
+
+	// Insert with timestamp
+	if err := db.InsertWithTimestamp(ctx, user, art, createdTime); err != nil {
+		return err
+	}
+	return organizeRecord(ctx, record, db, user, art.Url)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// An article to import, whichever service it was exported from
+type record struct {
+	Title     string
+	URL       string
+	TimeAdded int64 // seconds since the epoch
+	Tags      []string
+	Archived  bool
+	// The article's HTML, for exports that carry it. The article is only
+	// fetched if there is none.
+	Html []byte
+}
+
+// Reads the export of one service
+type importer interface {
+	Name() string
+	// Whether the data looks like an export this importer reads
+	Detect(data []byte) bool
+	Parse(data []byte) ([]record, error)
+}
+
+// In the order they are tried when detecting the format
+var importers = []importer{
+	pocketImporter{},
+	instapaperImporter{},
+	omnivoreImporter{},
+	wallabagImporter{},
+	bookmarksImporter{},
+	failuresImporter{},
+}
+
+// Returns the named importer, or the one that recognizes the data if the
+// format is auto
+func findImporter(format string, data []byte) (importer, error) {
+	for _, imp := range importers {
+		if format == "auto" && imp.Detect(data) || format == imp.Name() {
+			return imp, nil
+		}
+	}
+	if format == "auto" {
+		return nil, fmt.Errorf("unrecognized export format")
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+func importerNames() string {
+	var names []string
+	for _, imp := range importers {
+		names = append(names, imp.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns the time the article was added, or now if the export didn't say
+func (r record) created() time.Time {
+	if r.TimeAdded <= 0 {
+		return time.Now()
+	}
+	return time.Unix(r.TimeAdded, 0)
+}
+
+// A CSV export whose columns are found by name, since services add and
+// reorder them over time
+type csvTable struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+func readCSV(data []byte) (*csvTable, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+	table := &csvTable{columns: map[string]int{}, rows: rows[1:]}
+	for i, name := range rows[0] {
+		table.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	return table, nil
+}
+
+// Whether the first line of the data is a CSV header with the columns
+func hasCSVHeader(data []byte, columns ...string) bool {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	table, err := readCSV(line)
+	if err != nil {
+		return false
+	}
+	for _, column := range columns {
+		if _, ok := table.columns[column]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a field of a row, or the empty string if the row or the table
+// doesn't have it
+func (t *csvTable) get(row []string, column string) string {
+	i, ok := t.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
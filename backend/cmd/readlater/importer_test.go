@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+var exports = map[string]string{
+	"pocket": `title,url,time_added,tags,status
+Biscuits,https://example.com/biscuits,1700000000,baking|recipes/bread,archive
+Tofu,https://example.com/tofu,1700000100,,unread
+`,
+	"instapaper": `URL,Title,Selection,Folder,Timestamp,Tags
+https://example.com/biscuits,Biscuits,,Archive,1700000000,"[""baking""]"
+https://example.com/tofu,Tofu,,Recipes,1700000100,[]
+`,
+	"omnivore": `[
+  {"id": "1", "title": "Biscuits", "url": "https://example.com/biscuits", "state": "Archived",
+   "labels": ["baking", "recipes/bread"], "savedAt": "2023-11-14T22:13:20.000Z"},
+  {"id": "2", "title": "Tofu", "url": "https://example.com/tofu", "state": "Succeeded",
+   "labels": [], "savedAt": "2023-11-14T22:15:00.000Z"}
+]`,
+	"wallabag": `[
+  {"is_archived": 1, "is_starred": 0, "tags": ["baking"], "id": 1, "title": "Biscuits",
+   "url": "https://example.com/biscuits", "content": "<p>Flour and butter</p>",
+   "created_at": "2023-11-14T23:13:20+0100"},
+  {"is_archived": 0, "is_starred": 0, "tags": [], "id": 2, "title": "Tofu",
+   "url": "https://example.com/tofu", "content": "", "created_at": "2023-11-14T23:15:00+0100"}
+]`,
+	"bookmarks": `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1700000000">Recipes</H3>
+    <DL><p>
+        <DT><H3>Bread</H3>
+        <DL><p>
+            <DT><A HREF="https://example.com/biscuits" ADD_DATE="1700000000" TAGS="baking">Biscuits</A>
+        </DL><p>
+    </DL><p>
+    <DT><A HREF="https://example.com/tofu" ADD_DATE="1700000100">Tofu</A>
+    <DT><A HREF="javascript:alert(1)">Bookmarklet</A>
+</DL><p>
+`,
+}
+
+func TestDetectFormat(t *testing.T) {
+	for name, data := range exports {
+		imp, err := findImporter("auto", []byte(data))
+		assert.NilError(t, err)
+		assert.Equal(t, name, imp.Name())
+	}
+	_, err := findImporter("auto", []byte("hello"))
+	assert.ErrorContains(t, err, "unrecognized")
+	_, err = findImporter("delicious", []byte(exports["pocket"]))
+	assert.ErrorContains(t, err, "unknown format")
+}
+
+func recordTags(t *testing.T, r record) []string {
+	tags, err := normalizeTags(r.Tags)
+	assert.NilError(t, err)
+	return tags
+}
+
+func TestParseExports(t *testing.T) {
+	for name, data := range exports {
+		imp, err := findImporter(name, []byte(data))
+		assert.NilError(t, err)
+		records, err := imp.Parse([]byte(data))
+		assert.NilError(t, err, name)
+		assert.Equal(t, 2, len(records), name)
+
+		assert.Equal(t, "https://example.com/biscuits", records[0].URL, name)
+		assert.Equal(t, "Biscuits", records[0].Title, name)
+		assert.Equal(t, int64(1700000000), records[0].TimeAdded, name)
+		assert.Assert(t, len(recordTags(t, records[0])) > 0, name)
+		assert.Equal(t, "https://example.com/tofu", records[1].URL, name)
+		assert.Assert(t, !records[1].Archived, name)
+	}
+}
+
+func TestParseDetails(t *testing.T) {
+	parse := func(name string) []record {
+		imp, err := findImporter(name, nil)
+		assert.NilError(t, err)
+		records, err := imp.Parse([]byte(exports[name]))
+		assert.NilError(t, err)
+		return records
+	}
+
+	pocket := parse("pocket")
+	assert.DeepEqual(t, []string{"baking", "recipes/bread"}, recordTags(t, pocket[0]))
+	assert.Assert(t, pocket[0].Archived)
+	assert.Equal(t, 0, len(recordTags(t, pocket[1])))
+
+	// custom folders become tags
+	instapaper := parse("instapaper")
+	assert.Assert(t, instapaper[0].Archived)
+	assert.DeepEqual(t, []string{"baking"}, instapaper[0].Tags)
+	assert.DeepEqual(t, []string{"Recipes"}, instapaper[1].Tags)
+
+	assert.Assert(t, parse("omnivore")[0].Archived)
+
+	// the saved content is used instead of fetching
+	wallabag := parse("wallabag")
+	assert.Assert(t, wallabag[0].Archived)
+	assert.Equal(t, "<p>Flour and butter</p>", string(wallabag[0].Html))
+	assert.Assert(t, wallabag[1].Html == nil)
+
+	// folders become a tag
+	bookmarks := parse("bookmarks")
+	assert.DeepEqual(t, []string{"baking", "Recipes/Bread"}, bookmarks[0].Tags)
+	assert.Equal(t, 0, len(bookmarks[1].Tags))
+	assert.Equal(t, int64(1700000100), bookmarks[1].TimeAdded)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func asyncSummarize(t *testing.T, db library.Repo, queue *library.IngestQueue, user library.User, url string) library.Article {
+	data, err := json.Marshal(map[string]any{"url": url, "async": true})
+	assert.NilError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/summarize", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	summarize(mockSummarizer, db, mockFetcher, queue)(w, req, user)
+	resp := w.Result()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var art library.Article
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&art))
+	return art
+}
+
+// Waits for the stored article to reach the given status
+func waitForStatus(t *testing.T, db library.Repo, url string, status string) *library.Article {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		art, ok := db.GetWithoutUpdating(context.Background(), url)
+		if ok && art.Status == status {
+			return art
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never became %s", url, status)
+	return nil
+}
+
+func TestIngestQueue(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	user := library.User("test@example.com")
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	fetcher := func(ctx context.Context, url string) ([]byte, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[url]++
+		switch url {
+		case "https://flaky.example.com/story":
+			if attempts[url] < 3 {
+				return nil, "", errors.New("connection reset")
+			}
+		case "https://dead.example.com/story":
+			return nil, "", errors.New("no such host")
+		case "https://short.example.com/x":
+			return mockFetcher(ctx, "https://example.com/story")
+		}
+		return mockFetcher(ctx, url)
+	}
+	queue := library.NewIngestQueue(db, mockSummarizer, fetcher, 2, 3, time.Millisecond, time.Minute)
+	queue.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	// the request returns before the article is fetched
+	art := asyncSummarize(t, db, queue, user, "https://example.com/story?utm_source=feed")
+	assert.Equal(t, library.StatusPending, art.Status)
+	assert.Equal(t, "https://example.com/story?utm_source=feed", art.Url)
+
+	// once fetched it is stored under its canonical url
+	ready := waitForStatus(t, db, "https://example.com/story", library.StatusReady)
+	assert.Equal(t, "summary for html for https://example.com/story?utm_source=feed", ready.Title)
+	list, err := db.Recents(context.Background(), user, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "https://example.com/story", list[0].Url)
+	assert.Assert(t, list[0].HasBody)
+
+	// transient failures are retried
+	asyncSummarize(t, db, queue, user, "https://flaky.example.com/story")
+	waitForStatus(t, db, "https://flaky.example.com/story", library.StatusReady)
+
+	// persistent failures are given up on, but the article is kept
+	asyncSummarize(t, db, queue, user, "https://dead.example.com/story")
+	waitForStatus(t, db, "https://dead.example.com/story", library.StatusFailed)
+	list, err = db.GetChangesSince(context.Background(), user, "2000-01-01 00:00:00")
+	assert.NilError(t, err)
+	found := false
+	for _, entry := range list {
+		if entry.Url == "https://dead.example.com/story" {
+			found = true
+			assert.Equal(t, library.StatusFailed, entry.Status)
+			assert.Assert(t, !entry.HasBody)
+		}
+	}
+	assert.Assert(t, found)
+	mu.Lock()
+	assert.Equal(t, 3, attempts["https://dead.example.com/story"])
+	mu.Unlock()
+
+	// a short link to an article we already have joins the existing one
+	other := library.User("other@example.com")
+	asyncSummarize(t, db, queue, other, "https://short.example.com/x")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if art, ok := db.GetWithoutUpdating(context.Background(), "https://short.example.com/x"); ok && art.Url == "https://example.com/story" {
+			break
+		}
+		assert.Assert(t, time.Now().Before(deadline))
+		time.Sleep(10 * time.Millisecond)
+	}
+	list, err = db.Recents(context.Background(), other, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "https://example.com/story", list[0].Url)
+
+	// and is remembered, so adding it again doesn't fetch it
+	third := library.User("third@example.com")
+	data, err := json.Marshal(map[string]any{"url": "https://short.example.com/x", "async": true})
+	assert.NilError(t, err)
+	w := httptest.NewRecorder()
+	summarize(mockSummarizer, db, fetcher, queue)(w, httptest.NewRequest(http.MethodPost, "/summarize", bytes.NewReader(data)), third)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	mu.Lock()
+	assert.Equal(t, 1, attempts["https://short.example.com/x"])
+	mu.Unlock()
+	_, ok := db.LibraryArticle(context.Background(), third, "https://example.com/story")
+	assert.Assert(t, ok)
+}
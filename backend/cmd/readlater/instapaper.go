@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+)
+
+// Reads the CSV that Instapaper exports. Articles are in the Unread,
+// Archive or Starred folder or one of the user's own, which become tags.
+// Newer exports also have a column of tags as a JSON array.
+type instapaperImporter struct{}
+
+func (instapaperImporter) Name() string { return "instapaper" }
+
+func (instapaperImporter) Detect(data []byte) bool {
+	return hasCSVHeader(data, "url", "folder", "timestamp")
+}
+
+func (instapaperImporter) Parse(data []byte) ([]record, error) {
+	table, err := readCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	for i, row := range table.rows {
+		url := table.get(row, "url")
+		if url == "" {
+			log.Printf("Skipping row %d: no URL", i+2)
+			continue
+		}
+		r := record{Title: table.get(row, "title"), URL: url}
+		if timestamp := table.get(row, "timestamp"); timestamp != "" {
+			r.TimeAdded, err = strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				log.Printf("Skipping row %d: invalid timestamp '%s': %v", i+2, timestamp, err)
+				continue
+			}
+		}
+		switch folder := table.get(row, "folder"); folder {
+		case "Unread", "":
+		case "Archive":
+			r.Archived = true
+		case "Starred":
+			r.Tags = append(r.Tags, "starred")
+		default:
+			r.Tags = append(r.Tags, folder)
+		}
+		if tags := table.get(row, "tags"); tags != "" {
+			var list []string
+			if err := json.Unmarshal([]byte(tags), &list); err != nil {
+				log.Printf("Ignoring tags on row %d: %v", i+2, err)
+			}
+			r.Tags = append(r.Tags, list...)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+}
+
+// llmClient speaks the OpenAI chat completions API, which most hosted and
+// local model servers also implement
+type llmClient struct {
+	baseUrl string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func newLlmClient(baseUrl string, apiKey string, model string, timeout time.Duration) *llmClient {
+	return &llmClient{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Returns the model's reply to the messages along with the tokens it used.
+// The usage is returned whenever the server reports it, even if the reply
+// turns out to be unusable.
+func (c *llmClient) Complete(ctx context.Context, messages []chatMessage) (string, *chatUsage, error) {
+	body, err := json.Marshal(chatRequest{Model: c.model, Messages: messages})
+	if err != nil {
+		return "", nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseUrl+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", nil, fmt.Errorf("chat completion failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var result chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("error decoding chat completion: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", result.Usage, fmt.Errorf("chat completion has no choices")
+	}
+	return result.Choices[0].Message.Content, result.Usage, nil
+}
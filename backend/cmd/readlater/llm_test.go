@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+// A stand-in for a chat completions server that summarizes by taking the
+// first sentence
+func stubLlmServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var req chatRequest
+		assert.NilError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "stub-model", req.Model)
+		assert.Equal(t, 2, len(req.Messages))
+		article := req.Messages[1].Content
+		if strings.Contains(article, "overload") {
+			http.Error(w, "too busy", http.StatusServiceUnavailable)
+			return
+		}
+		first, _, _ := strings.Cut(article, ".")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{
+				map[string]any{"message": map[string]string{"role": "assistant", "content": " " + first + ".\n"}},
+			},
+			"usage": map[string]int{"prompt_tokens": len(article) / 4, "completion_tokens": 7},
+		})
+	}))
+}
+
+func TestLlmSummarizer(t *testing.T) {
+	server := stubLlmServer(t)
+	defer server.Close()
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+
+	client := newLlmClient(server.URL+"/v1/", "secret", "stub-model", time.Second)
+	summarizer := llmSummarizer(client, db, 1000)
+
+	html := "<html><body><p>Aardvarks eat ants. They dig for them at night.</p></body></html>"
+	result, err := summarizer(ctx, "https://example.com/aardvarks", []byte(html))
+	assert.NilError(t, err)
+	assert.Equal(t, "Aardvarks eat ants. They dig for them at night.", result.Contents)
+	assert.Equal(t, "Aardvarks eat ants.", result.Tldr)
+
+	// failures still save the article, just without a TL;DR
+	html = "<html><body><p>This one will overload the server.</p></body></html>"
+	result, err = summarizer(ctx, "https://example.com/overload", []byte(html))
+	assert.NilError(t, err)
+	assert.Equal(t, "This one will overload the server.", result.Contents)
+	assert.Equal(t, "", result.Tldr)
+
+	// the successful call was recorded
+	rows, err := db.DB().QueryContext(ctx, "SELECT url, model, lengthIn, lengthOut, tokensIn, tokensOut FROM usage")
+	assert.NilError(t, err)
+	defer rows.Close()
+	var usages []library.Usage
+	for rows.Next() {
+		var u library.Usage
+		assert.NilError(t, rows.Scan(&u.Url, &u.Model, &u.LengthIn, &u.LengthOut, &u.TokensIn, &u.TokensOut))
+		usages = append(usages, u)
+	}
+	assert.Equal(t, 1, len(usages))
+	assert.Equal(t, library.Usage{Url: "https://example.com/aardvarks", Model: "stub-model", LengthIn: 47, LengthOut: 21, TokensIn: 11, TokensOut: 7}, usages[0])
+}
+
+// The TL;DR is stored with the article
+func TestArticleSummary(t *testing.T) {
+	server := stubLlmServer(t)
+	defer server.Close()
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+
+	client := newLlmClient(server.URL+"/v1", "secret", "stub-model", time.Second)
+	summarizer := llmSummarizer(client, db, 1000)
+	fetcher := func(_ context.Context, url string) ([]byte, string, error) {
+		return []byte("<html><body><h1>Baboons</h1><p>Baboons live in troops. Troops are large.</p></body></html>"), url, nil
+	}
+	art, _, err := library.FetchArticle(ctx, db, summarizer, fetcher, "https://example.com/baboons", "")
+	assert.NilError(t, err)
+	assert.NilError(t, db.Insert(ctx, library.User("test@example.com"), art))
+
+	stored, ok := db.Get(ctx, library.User("test@example.com"), "https://example.com/baboons")
+	assert.Assert(t, ok)
+	assert.Equal(t, "Baboons", stored.Title)
+	assert.Equal(t, "# Baboons\n\nBaboons live in troops.", stored.Summary)
+}
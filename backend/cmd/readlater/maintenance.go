@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Clears out expired sessions, the trash and old tombstones and compacts
+// the database. The server should be stopped first. Run as: readlater vacuum
+func vacuumCommand(db library.Repo, tombstoneHorizon time.Duration, trashRetention time.Duration, args []string) error {
+	flags := flag.NewFlagSet("vacuum", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := db.PruneSessions(ctx); err != nil {
+		return fmt.Errorf("failed to prune sessions: %w", err)
+	}
+	if _, err := db.PurgeTrash(ctx, trashRetention); err != nil {
+		return fmt.Errorf("failed to purge trash: %w", err)
+	}
+	if err := db.PruneTombstones(ctx, tombstoneHorizon); err != nil {
+		return fmt.Errorf("failed to prune tombstones: %w", err)
+	}
+	if err := db.Vacuum(ctx); err != nil {
+		return fmt.Errorf("failed to vacuum: %w", err)
+	}
+	return nil
+}
+
+// Removes articles that have been in the trash for longer than the
+// retention period and forgets deletions older than the tombstone horizon,
+// checking every interval until the context is done
+func purgeExpired(ctx context.Context, db library.Repo, trashRetention time.Duration, tombstoneHorizon time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		count, err := db.PurgeTrash(ctx, trashRetention)
+		if err != nil {
+			log.Printf("Error purging trash: %v", err)
+		} else if count > 0 {
+			log.Printf("Purged %d articles from the trash", count)
+		}
+		if err := db.PruneTombstones(ctx, tombstoneHorizon); err != nil {
+			log.Printf("Error pruning tombstones: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prints counts of what the database holds. Run as: readlater stats
+func statsCommand(db library.Repo, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	stats, err := db.Stats(context.Background())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var statuses []string
+	total := 0
+	for status, count := range stats.Articles {
+		statuses = append(statuses, status)
+		total += count
+	}
+	slices.Sort(statuses)
+	fmt.Fprintf(tw, "Articles\t%d\t\n", total)
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "  %s\t%d\t\n", status, stats.Articles[status])
+	}
+	fmt.Fprintf(tw, "Users\t%d\t\n", stats.Users)
+	fmt.Fprintf(tw, "Library entries\t%d\t\n", stats.LibraryEntries)
+	fmt.Fprintf(tw, "  unread\t%d\t\n", stats.Unread)
+	fmt.Fprintf(tw, "  archived\t%d\t\n", stats.Archived)
+	fmt.Fprintf(tw, "  trashed\t%d\t\n", stats.Trashed)
+	fmt.Fprintf(tw, "Tags\t%d\t\n", stats.Tags)
+	fmt.Fprintf(tw, "Pending jobs\t%d\t\n", stats.Jobs)
+	fmt.Fprintf(tw, "Tombstones\t%d\t\n", stats.Tombstones)
+	return tw.Flush()
+}
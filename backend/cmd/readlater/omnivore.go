@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Reads the metadata JSON files in an Omnivore export, in which labels are
+// tags
+type omnivoreImporter struct{}
+
+type omnivoreItem struct {
+	Title   string   `json:"title"`
+	Url     string   `json:"url"`
+	State   string   `json:"state"`
+	Labels  []string `json:"labels"`
+	SavedAt string   `json:"savedAt"`
+	// Set for archived articles in some versions of the export
+	ArchivedAt *string `json:"archivedAt"`
+}
+
+func (omnivoreImporter) Name() string { return "omnivore" }
+
+func (omnivoreImporter) Detect(data []byte) bool {
+	return jsonArrayHas(data, "savedAt")
+}
+
+func (omnivoreImporter) Parse(data []byte) ([]record, error) {
+	var items []omnivoreItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	var records []record
+	for _, item := range items {
+		if item.Url == "" {
+			continue
+		}
+		r := record{
+			Title:    item.Title,
+			URL:      item.Url,
+			Tags:     item.Labels,
+			Archived: item.State == "Archived" || item.ArchivedAt != nil,
+		}
+		if saved, err := time.Parse(time.RFC3339, item.SavedAt); err == nil {
+			r.TimeAdded = saved.Unix()
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Whether the data is a JSON array whose first element has the field
+func jsonArrayHas(data []byte, field string) bool {
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil || len(items) == 0 {
+		return false
+	}
+	_, ok := items[0][field]
+	return ok
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Reads the CSV that Pocket exports, whose tags are separated by pipes
+type pocketImporter struct{}
+
+func (pocketImporter) Name() string { return "pocket" }
+
+func (pocketImporter) Detect(data []byte) bool {
+	return hasCSVHeader(data, "url", "time_added")
+}
+
+func (pocketImporter) Parse(data []byte) ([]record, error) {
+	table, err := readCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	for i, row := range table.rows {
+		url := table.get(row, "url")
+		if url == "" {
+			log.Printf("Skipping row %d: no URL", i+2)
+			continue
+		}
+		timeAdded, err := strconv.ParseInt(table.get(row, "time_added"), 10, 64)
+		if err != nil {
+			log.Printf("Skipping row %d: invalid timestamp '%s': %v", i+2, table.get(row, "time_added"), err)
+			continue
+		}
+		records = append(records, record{
+			Title:     table.get(row, "title"),
+			URL:       url,
+			TimeAdded: timeAdded,
+			Tags:      strings.Split(table.get(row, "tags"), "|"),
+			Archived:  table.get(row, "status") == "archive",
+		})
+	}
+	return records, nil
+}
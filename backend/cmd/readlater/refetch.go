@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"github.com/rcbilson/readlater/www"
+)
+
+// Fetches stored articles again, to convert them anew or to retry those
+// that couldn't be fetched. Run as:
+// readlater refetch [-failed] [-archive url] [-limit n] [-dry-run]
+func refetchCommand(db library.Repo, summarizer library.SummarizeFunc, args []string) error {
+	flags := flag.NewFlagSet("refetch", flag.ContinueOnError)
+	onlyFailed := flags.Bool("failed", false, "only refetch the articles that couldn't be fetched")
+	archiveURL := flags.String("archive", "", "availability API to find snapshots of unreachable articles in, such as https://archive.org/wayback/available")
+	limit := flags.Int("limit", 0, "refetch at most this many articles, or 0 for all")
+	dryRun := flags.Bool("dry-run", false, "fetch and convert the articles without storing them")
+	opts := batchFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	arts, err := db.StoredArticles(context.Background(), *onlyFailed, *limit)
+	if err != nil {
+		return fmt.Errorf("failed to list articles: %w", err)
+	}
+	return refetchArticles(arts, db, www.FetcherCombined, summarizer, newArchive(*archiveURL), *dryRun, *opts)
+}
+
+func refetchArticles(arts []library.Article, db library.Repo, fetcher www.FetcherFunc, summarizer library.SummarizeFunc, archive *archive, dryRun bool, opts batchOptions) error {
+	byURL := map[string]library.Article{}
+	var records []record
+	for _, art := range arts {
+		byURL[art.Url] = art
+		records = append(records, record{Title: art.Title, URL: art.Url})
+	}
+
+	limiter := newHostLimiter(opts.PerHost, opts.HostDelay)
+	counts, err := runBatch(records, opts, func(ctx context.Context, record record) result {
+		return refetchArticle(ctx, byURL[record.URL], db, fetcher, summarizer, limiter, opts.Timeout, archive, dryRun)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nRefetch summary:\n")
+	fmt.Printf("  Articles: %d\n", counts.total)
+	fmt.Printf("  Refetched: %d\n", counts.outcomes[refetched])
+	fmt.Printf("  Unchanged: %d\n", counts.outcomes[unchanged])
+	fmt.Printf("  Failed: %d\n", counts.outcomes[failed])
+	if dryRun {
+		fmt.Printf("\nThis was a dry run. Nothing was stored.\n")
+	}
+	return nil
+}
+
+// Fetches an article again, from the archive if the site no longer has it,
+// giving up once it has taken the timeout
+func refetchArticle(ctx context.Context, art library.Article, db library.Repo, fetcher www.FetcherFunc, summarizer library.SummarizeFunc, limiter *hostLimiter, timeout time.Duration, archive *archive, dryRun bool) result {
+	record := record{Title: art.Title, URL: art.Url}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	outcome, err := func() (outcome, error) {
+		parsed, err := url.Parse(art.Url)
+		if err != nil {
+			return failed, fmt.Errorf("invalid URL: %w", err)
+		}
+		release, err := limiter.acquire(ctx, parsed.Host)
+		if err != nil {
+			return failed, err
+		}
+		defer release()
+
+		html, _, err := fetcher(ctx, art.Url)
+		if err != nil && archive != nil {
+			snapshot, aerr := archive.snapshot(ctx, art.Url)
+			if aerr != nil {
+				return failed, fmt.Errorf("%w (and the archive lookup failed: %w)", err, aerr)
+			}
+			if snapshot != "" {
+				html, _, err = fetcher(ctx, snapshot)
+			}
+		}
+		if err != nil {
+			return failed, fmt.Errorf("%w: %v", library.ErrFetchFailed, err)
+		}
+
+		summary, err := summarizer(ctx, art.Url, html)
+		if err != nil {
+			return failed, fmt.Errorf("error extracting article text: %w", err)
+		}
+		if art.Status == library.StatusReady && strings.TrimSpace(summary.Contents) == strings.TrimSpace(art.Contents) {
+			return unchanged, nil
+		}
+
+		// Articles that were never fetched only have the title they were
+		// saved with, which may be just the url
+		title := art.Title
+		if art.Status != library.StatusReady {
+			hint := art.Title
+			if hint == art.Url {
+				hint = ""
+			}
+			title = library.ExtractTitle(&summary.Contents, html, art.Url, hint)
+		}
+		if dryRun {
+			return refetched, nil
+		}
+		return refetched, db.UpdateContents(ctx, &library.Article{Title: title, Url: art.Url, Contents: summary.Contents, Summary: summary.Tldr})
+	}()
+	if err != nil {
+		if art.Status == library.StatusFailed && !dryRun {
+			if serr := db.SetFetchError(context.WithoutCancel(ctx), art.Url, err.Error()); serr != nil {
+				err = fmt.Errorf("%w (and failed to record it: %w)", err, serr)
+			}
+		}
+		return result{record, failed, err}
+	}
+	return result{record, outcome, nil}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/rcbilson/readlater/internal/library"
+	"github.com/rcbilson/readlater/www"
+)
+
+type specification struct {
+	Port         int    `default:"9000"`
+	FrontendPath string `default:"/home/richard/src/readlater/frontend/dist"`
+	DbFile       string `default:"/home/richard/src/readlater/data/readlater.db"`
+	GClientId    string `default:"250293909105-5da8lue96chip31p2q3ueug0bdvve96o.apps.googleusercontent.com"`
+	// Google's signing keys are read from JwksFile if set, else JwksUrl
+	JwksFile     string
+	JwksUrl      string        `default:"https://www.googleapis.com/oauth2/v3/certs"`
+	SessionTTL   time.Duration `default:"720h"`
+	AllowedUsers []string
+	NoAuth       bool
+	// Users who may see the LLM usage of the whole server
+	Admins []string
+	// Without signing in nobody is an admin, unless this lets everyone be
+	NoAuthAdmin bool
+	// Owner of the articles saved before libraries were per-user
+	LegacyUser string
+	// Background fetching of articles
+	FetchWorkers  int           `default:"2"`
+	FetchAttempts int           `default:"5"`
+	FetchBackoff  time.Duration `default:"1m"`
+	// How long fetching a page may take, to which the llm summarizer adds
+	// LlmTimeout
+	FetchTimeout time.Duration `default:"1m"`
+	// Summarizer is "markdown" to only convert pages, or "llm" to also ask
+	// an OpenAI-compatible chat completions API for a TL;DR
+	Summarizer  string `default:"markdown"`
+	LlmUrl      string `default:"https://api.openai.com/v1"`
+	LlmApiKey   string
+	LlmModel    string        `default:"gpt-4o-mini"`
+	LlmTimeout  time.Duration `default:"2m"`
+	LlmMaxInput int           `default:"100000"`
+	// Once this many tokens have been used in a month articles are only
+	// converted to markdown. Zero means no limit.
+	LlmMonthlyTokens int
+	// Dollars per million tokens by model, as model:input/output
+	LlmPrices map[string]string
+	// How long clients syncing changes are told about deleted articles.
+	// Those that haven't synced for longer have to sync afresh.
+	TombstoneHorizon time.Duration `default:"2160h"`
+	// How long deleted articles can be restored from the trash, and how
+	// often those that have been there longer, and deletions older than
+	// TombstoneHorizon, are purged
+	TrashRetention     time.Duration `default:"720h"`
+	TrashPurgeInterval time.Duration `default:"1h"`
+	// Roughly how much of the changes feed to send at once with contents
+	ChangesPageBytes int `default:"1048576"`
+	// How often idle event streams send a heartbeat
+	EventHeartbeat time.Duration `default:"30s"`
+	// JSON file of rules deciding which url an article is stored under, in
+	// place of the default rules
+	CanonicalRules string
+}
+
+var spec specification
+
+const usage = `usage: readlater <command> [flags]
+
+Commands:
+  serve         run the server
+  import        import an export from another service
+  refetch       fetch stored articles again
+  canonicalize  store articles under their canonical urls, merging duplicates
+  export        export a user's library
+  vacuum        prune expired data and compact the database
+  stats         show counts of what the database holds
+  usage         report LLM usage and its cost
+
+Run readlater <command> -h for a command's flags. Settings such as the
+database file are read from READLATER_* environment variables.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	err := envconfig.Process("readlater", &spec)
+	if err != nil {
+		log.Fatal("error reading environment variables:", err)
+	}
+
+	prices, err := parsePrices(spec.LlmPrices)
+	if err != nil {
+		log.Fatal("error reading prices:", err)
+	}
+
+	var rules *library.CanonicalRules
+	if spec.CanonicalRules != "" {
+		rules, err = library.LoadCanonicalRules(spec.CanonicalRules)
+		if err != nil {
+			log.Fatal("error reading canonicalization rules:", err)
+		}
+	}
+
+	db, err := library.NewRepo(spec.DbFile, rules)
+	if err != nil {
+		log.Fatal("error initializing database interface:", err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "serve":
+		err = serveCommand(db, prices, args)
+	case "import":
+		err = importCommand(db, mustSummarizer(db), args)
+	case "refetch":
+		err = refetchCommand(db, mustSummarizer(db), args)
+	case "canonicalize":
+		err = canonicalizeCommand(db, args)
+	case "export":
+		err = exportCommand(db, args)
+	case "vacuum":
+		err = vacuumCommand(db, spec.TombstoneHorizon, spec.TrashRetention, args)
+	case "stats":
+		err = statsCommand(db, args)
+	case "usage":
+		err = usageCommand(db, prices, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		db.Close()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func mustSummarizer(db library.Repo) library.SummarizeFunc {
+	summarizer, err := newSummarizer(spec, db)
+	if err != nil {
+		log.Fatal("error initializing summarizer:", err)
+	}
+	return summarizer
+}
+
+// Runs the server until it fails
+func serveCommand(db library.Repo, prices priceTable, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	summarizer, err := newSummarizer(spec, db)
+	if err != nil {
+		return fmt.Errorf("error initializing summarizer: %w", err)
+	}
+
+	if spec.LegacyUser != "" {
+		err = db.ClaimLegacyArticles(context.Background(), library.User(spec.LegacyUser))
+		if err != nil {
+			return fmt.Errorf("error claiming legacy articles: %w", err)
+		}
+	}
+
+	var auth *authenticator
+	if !spec.NoAuth {
+		if len(spec.AllowedUsers) == 0 {
+			return errors.New("READLATER_ALLOWEDUSERS must list who may sign in, or READLATER_NOAUTH be set")
+		}
+		verifier := newIdTokenVerifier(spec.GClientId, spec.JwksFile, spec.JwksUrl)
+		auth = newAuthenticator(db, verifier, spec.SessionTTL, spec.AllowedUsers)
+	}
+	admins := spec.Admins
+	if spec.NoAuth && spec.NoAuthAdmin {
+		// every request is made by the same nameless user
+		admins = []string{""}
+	}
+
+	jobTimeout := spec.FetchTimeout
+	if spec.Summarizer == "llm" {
+		jobTimeout += spec.LlmTimeout
+	}
+	queue := library.NewIngestQueue(db, summarizer, www.Fetcher, spec.FetchWorkers, spec.FetchAttempts, spec.FetchBackoff, jobTimeout)
+	go queue.Run(context.Background())
+	go purgeExpired(context.Background(), db, spec.TrashRetention, spec.TombstoneHorizon, spec.TrashPurgeInterval)
+
+	handler(summarizer, db, www.Fetcher, queue, prices, spec.ChangesPageBytes, spec.EventHeartbeat, spec.Port, spec.FrontendPath, auth, admins)
+	return nil
+}
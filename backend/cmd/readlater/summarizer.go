@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+const tldrPrompt = `You will be given an article in markdown. Write a TL;DR of it: ` +
+	`two to four plain sentences giving the main points, with no preamble, ` +
+	`headings or markdown formatting.`
+
+// llmSummarizer converts the article to markdown and asks the model for a
+// TL;DR of it. The tokens used by every call are recorded in the usage
+// table. At most maxInput characters of the article are sent to the model.
+// If the model fails the article is saved without a TL;DR, so that an
+// outage or a bad key doesn't stop articles being saved.
+func llmSummarizer(client *llmClient, db library.Repo, maxInput int) library.SummarizeFunc {
+	toMarkdown := library.HtmlToMarkdownSummarizer()
+	return func(ctx context.Context, url string, article []byte) (library.Summary, error) {
+		result, err := toMarkdown(ctx, url, article)
+		if err != nil {
+			return library.Summary{}, err
+		}
+		input := result.Contents
+		if maxInput > 0 && len(input) > maxInput {
+			input = strings.ToValidUTF8(input[:maxInput], "")
+		}
+		reply, usage, err := client.Complete(ctx, []chatMessage{
+			{Role: "system", Content: tldrPrompt},
+			{Role: "user", Content: input},
+		})
+		if usage != nil {
+			err := db.Usage(ctx, library.Usage{
+				Url:       url,
+				Model:     client.model,
+				LengthIn:  len(input),
+				LengthOut: len(reply),
+				TokensIn:  usage.PromptTokens,
+				TokensOut: usage.CompletionTokens,
+			})
+			if err != nil {
+				log.Printf("Error recording usage for %s: %v", url, err)
+			}
+		}
+		if err != nil {
+			log.Printf("Error summarizing %s, saving it without a TL;DR: %v", url, err)
+			return result, nil
+		}
+		result.Tldr = strings.TrimSpace(reply)
+		return result, nil
+	}
+}
+
+// Returns the summarizer named in the configuration
+func newSummarizer(spec specification, db library.Repo) (library.SummarizeFunc, error) {
+	switch spec.Summarizer {
+	case "", "markdown":
+		return library.HtmlToMarkdownSummarizer(), nil
+	case "llm":
+		if spec.LlmModel == "" {
+			return nil, fmt.Errorf("the llm summarizer needs a model")
+		}
+		client := newLlmClient(spec.LlmUrl, spec.LlmApiKey, spec.LlmModel, spec.LlmTimeout)
+		summarizer := llmSummarizer(client, db, spec.LlmMaxInput)
+		if spec.LlmMonthlyTokens > 0 {
+			summarizer = withTokenBudget(summarizer, library.HtmlToMarkdownSummarizer(), db, spec.LlmMonthlyTokens)
+		}
+		return summarizer, nil
+	default:
+		return nil, fmt.Errorf("unknown summarizer: %s", spec.Summarizer)
+	}
+}
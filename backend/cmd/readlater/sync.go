@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+type syncRequest struct {
+	DeviceId string `json:"deviceId"`
+	// The client's clock when it sent the request, in milliseconds since the
+	// epoch. If given, mutation timestamps are corrected for the difference
+	// between the client's clock and ours.
+	ClientTime int64 `json:"clientTime"`
+	// The cursor returned by the last sync, empty to get everything, or
+	// missing to use since instead
+	Cursor    *string            `json:"cursor"`
+	Since     string             `json:"since"`
+	Mutations []library.Mutation `json:"mutations"`
+}
+
+type syncResponse struct {
+	Results []library.MutationResult `json:"results"`
+	Changes library.ArticleList      `json:"changes"`
+	// Pass back as cursor on the next sync
+	Cursor string `json:"cursor"`
+	// Pass back as since on the next sync, for clients without cursors
+	Since string `json:"since"`
+}
+
+// Applies a batch of changes made on a client and returns the changes made
+// on the server since the client last synced
+func syncChanges(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		ctx := r.Context()
+		now := time.Now()
+
+		var req syncRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.DeviceId == "" && len(req.Mutations) > 0 {
+			logError(w, "No device id provided", http.StatusBadRequest)
+			return
+		}
+
+		// A client that has missed deletions is told to sync afresh before
+		// its changes are applied, so that it sends them again then
+		var after changeCursor
+		if req.Cursor != nil {
+			after, err = decodeCursor(*req.Cursor)
+			if err != nil {
+				logError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = db.CheckChangesAfter(ctx, user, after.Seq)
+		} else {
+			err = db.CheckChangesSince(ctx, user, req.Since)
+		}
+		if errors.Is(err, library.ErrChangesPruned) {
+			logError(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if req.ClientTime > 0 {
+			skew := now.UnixMilli() - req.ClientTime
+			for i := range req.Mutations {
+				req.Mutations[i].Timestamp += skew
+			}
+		}
+		results, err := db.ApplyMutations(ctx, user, req.DeviceId, req.Mutations)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error applying changes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Change times only have a resolution of a second, so start the next
+		// sync a second early. Changes may be sent twice but aren't missed.
+		next := now.UTC().Add(-time.Second).Format(time.RFC3339)
+		var changes library.ArticleList
+		var upto int64
+		if req.Cursor != nil {
+			changes, upto, err = db.GetChangesAfter(ctx, user, after.Seq)
+			if err != nil {
+				logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			upto, err = db.ChangeSequence(ctx)
+			if err != nil {
+				logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if req.Since != "" {
+				changes, err = db.GetChangesSince(ctx, user, req.Since)
+				if err != nil {
+					logError(w, fmt.Sprintf("Error fetching article changes: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		if changes == nil {
+			changes = library.ArticleList{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(syncResponse{Results: results, Changes: changes, Cursor: encodeCursor(upto), Since: next})
+	}
+}
+
+// Cursors are positions in the change sequence. They are opaque to clients
+// so that what they hold can change.
+type changeCursor struct {
+	Seq int64
+	// Where the client last caught up, if it is partway through a feed
+	// that was split into pages
+	Base int64
+}
+
+func encodeCursor(seq int64) string {
+	return encodePageCursor(seq, seq)
+}
+
+func encodePageCursor(seq int64, base int64) string {
+	value := "seq:" + strconv.FormatInt(seq, 10)
+	if base != seq {
+		value += ":" + strconv.FormatInt(base, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// Returns the position in the change sequence held by a cursor. The empty
+// cursor is the start of the sequence.
+func decodeCursor(cursor string) (changeCursor, error) {
+	if cursor == "" {
+		return changeCursor{}, nil
+	}
+	invalid := fmt.Errorf("invalid cursor: %s", cursor)
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return changeCursor{}, invalid
+	}
+	value, ok := strings.CutPrefix(string(data), "seq:")
+	if !ok {
+		return changeCursor{}, invalid
+	}
+	seqStr, baseStr, paged := strings.Cut(value, ":")
+	var c changeCursor
+	if c.Seq, err = strconv.ParseInt(seqStr, 10, 64); err != nil || c.Seq < 0 {
+		return changeCursor{}, invalid
+	}
+	c.Base = c.Seq
+	if paged {
+		if c.Base, err = strconv.ParseInt(baseStr, 10, 64); err != nil || c.Base < 0 || c.Base > c.Seq {
+			return changeCursor{}, invalid
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func syncTest(t *testing.T, db library.Repo, user library.User, req syncRequest) syncResponse {
+	data, err := json.Marshal(req)
+	assert.NilError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/sync", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	syncChanges(db)(w, r, user)
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result syncResponse
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return result
+}
+
+func TestSync(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	a := "https://example.com/a"
+	b := "https://example.com/b"
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: a, Title: "A", Contents: "a"}))
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: b, Title: "B", Contents: "b"}))
+
+	now := time.Now().UnixMilli()
+	resp := syncTest(t, db, user, syncRequest{
+		DeviceId: "phone",
+		Since:    "2000-01-01 00:00:00",
+		Mutations: []library.Mutation{
+			{Url: a, Field: "unread", Value: false, Timestamp: now - 60000},
+			{Url: a, Field: "archived", Value: true, Timestamp: now - 60000},
+			{Url: "https://example.com/missing", Field: "archived", Value: true, Timestamp: now},
+			{Url: a, Field: "title", Value: true, Timestamp: now},
+		},
+	})
+	assert.Equal(t, 4, len(resp.Results))
+	assert.Assert(t, resp.Results[0].Applied)
+	assert.Assert(t, resp.Results[1].Applied)
+	assert.Assert(t, !resp.Results[2].Applied)
+	assert.Equal(t, "not in library", resp.Results[2].Error)
+	assert.Assert(t, !resp.Results[3].Applied)
+	assert.Assert(t, resp.Since != "")
+	// the changes include the ones just made
+	assert.Equal(t, 2, len(resp.Changes))
+	for _, entry := range resp.Changes {
+		if entry.Url == a {
+			assert.Assert(t, !entry.Unread && entry.Archived)
+		}
+	}
+
+	// a change made on another device since wins over a stale offline one
+	assert.NilError(t, db.SetArchive(ctx, user, a, false))
+	resp = syncTest(t, db, user, syncRequest{
+		DeviceId: "tablet",
+		Mutations: []library.Mutation{
+			{Url: a, Field: "archived", Value: true, Timestamp: now - 30000},
+			{Url: a, Field: "unread", Value: true, Timestamp: now - 30000},
+		},
+	})
+	assert.Assert(t, !resp.Results[0].Applied)
+	assert.Equal(t, "", resp.Results[0].Error)
+	assert.Assert(t, resp.Results[1].Applied)
+	list, _, err := db.ListArticles(ctx, user, library.ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(list))
+	for _, entry := range list {
+		if entry.Url == a {
+			assert.Assert(t, !entry.Archived)
+			assert.Assert(t, entry.Unread)
+		}
+	}
+
+	// ties go to the greater device id
+	resp = syncTest(t, db, user, syncRequest{
+		DeviceId:  "laptop",
+		Mutations: []library.Mutation{{Url: a, Field: "unread", Value: false, Timestamp: now - 30000}},
+	})
+	assert.Assert(t, !resp.Results[0].Applied)
+	resp = syncTest(t, db, user, syncRequest{
+		DeviceId:  "watch",
+		Mutations: []library.Mutation{{Url: a, Field: "unread", Value: false, Timestamp: now - 30000}},
+	})
+	assert.Assert(t, resp.Results[0].Applied)
+
+	// timestamps from a client whose clock is an hour slow are corrected
+	resp = syncTest(t, db, user, syncRequest{
+		DeviceId:   "slow",
+		ClientTime: time.Now().Add(-time.Hour).UnixMilli(),
+		Mutations:  []library.Mutation{{Url: b, Field: "archived", Value: true, Timestamp: time.Now().Add(-time.Hour).UnixMilli()}},
+	})
+	assert.Assert(t, resp.Results[0].Applied)
+	resp = syncTest(t, db, user, syncRequest{
+		DeviceId:  "fast",
+		Mutations: []library.Mutation{{Url: b, Field: "archived", Value: false, Timestamp: time.Now().Add(-30 * time.Minute).UnixMilli()}},
+	})
+	assert.Assert(t, !resp.Results[0].Applied)
+
+	// once a deletion is forgotten, clients that hadn't seen it are told to
+	// sync afresh, without their changes being applied
+	_, err = db.DB().Exec("DELETE FROM library WHERE url = ?", b)
+	assert.NilError(t, err)
+	_, err = db.DB().Exec("UPDATE tombstones SET deleted = datetime('now', '-2 days')")
+	assert.NilError(t, err)
+	assert.NilError(t, db.PruneTombstones(ctx, 24*time.Hour))
+	data, err := json.Marshal(syncRequest{
+		DeviceId:  "phone",
+		Since:     "2000-01-01 00:00:00",
+		Mutations: []library.Mutation{{Url: a, Field: "archived", Value: true, Timestamp: time.Now().UnixMilli()}},
+	})
+	assert.NilError(t, err)
+	w := httptest.NewRecorder()
+	syncChanges(db)(w, httptest.NewRequest(http.MethodPost, "/sync", bytes.NewReader(data)), user)
+	assert.Equal(t, http.StatusGone, w.Result().StatusCode)
+	list, _, err = db.ListArticles(ctx, user, library.ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, !list[0].Archived)
+}
+
+// Changes made within the same second are all seen exactly once
+func TestChangeCursor(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	a := "https://example.com/a"
+	b := "https://example.com/b"
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: a, Title: "A", Contents: "a"}))
+
+	changes := func(cursor string) (library.ArticleList, string) {
+		req := httptest.NewRequest(http.MethodGet, "/changes?cursor="+cursor, nil)
+		w := httptest.NewRecorder()
+		fetchChanges(db, 1<<20)(w, req, user)
+		resp := w.Result()
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var result struct {
+			Changes library.ArticleList `json:"changes"`
+			Cursor  string              `json:"cursor"`
+		}
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result.Changes, result.Cursor
+	}
+
+	// an empty cursor gets everything
+	list, cursor := changes("")
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, a, list[0].Url)
+
+	list, cursor = changes(cursor)
+	assert.Equal(t, 0, len(list))
+
+	assert.NilError(t, db.SetArchive(ctx, user, a, true))
+	list, cursor = changes(cursor)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Archived)
+	assert.NilError(t, db.SetArchive(ctx, user, a, false))
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: b, Title: "B", Contents: "b"}))
+	list, cursor = changes(cursor)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, a, list[0].Url)
+	assert.Assert(t, !list[0].Archived)
+	assert.Equal(t, b, list[1].Url)
+
+	_, err = db.DB().Exec("DELETE FROM articles WHERE url = ?", b)
+	assert.NilError(t, err)
+	list, cursor = changes(cursor)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Deleted)
+	list, _ = changes(cursor)
+	assert.Equal(t, 0, len(list))
+
+	// the sync endpoint takes cursors too
+	resp := syncTest(t, db, user, syncRequest{
+		DeviceId:  "phone",
+		Cursor:    &cursor,
+		Mutations: []library.Mutation{{Url: a, Field: "unread", Value: false, Timestamp: time.Now().UnixMilli()}},
+	})
+	assert.Equal(t, 1, len(resp.Changes))
+	assert.Assert(t, !resp.Changes[0].Unread)
+	list, _ = changes(resp.Cursor)
+	assert.Equal(t, 0, len(list))
+
+	req := httptest.NewRequest(http.MethodGet, "/changes?cursor=bogus", nil)
+	w := httptest.NewRecorder()
+	fetchChanges(db, 1<<20)(w, req, user)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// once the deletion is forgotten, clients that hadn't seen it are told
+	// to sync afresh, without their changes being applied
+	_, err = db.DB().Exec("UPDATE tombstones SET deleted = datetime('now', '-2 days')")
+	assert.NilError(t, err)
+	assert.NilError(t, db.PruneTombstones(ctx, 24*time.Hour))
+	for _, query := range []string{"cursor=" + encodeCursor(1), "since=2000-01-01T00:00:00Z"} {
+		req = httptest.NewRequest(http.MethodGet, "/changes?"+query, nil)
+		w = httptest.NewRecorder()
+		fetchChanges(db, 1<<20)(w, req, user)
+		assert.Equal(t, http.StatusGone, w.Result().StatusCode, query)
+	}
+	stale := encodeCursor(1)
+	data, err := json.Marshal(syncRequest{
+		DeviceId:  "phone",
+		Cursor:    &stale,
+		Mutations: []library.Mutation{{Url: a, Field: "archived", Value: true, Timestamp: time.Now().UnixMilli()}},
+	})
+	assert.NilError(t, err)
+	w = httptest.NewRecorder()
+	syncChanges(db)(w, httptest.NewRequest(http.MethodPost, "/sync", bytes.NewReader(data)), user)
+	assert.Equal(t, http.StatusGone, w.Result().StatusCode)
+	list, _ = changes("")
+	assert.Assert(t, !list[0].Archived)
+	list, _ = changes(resp.Cursor)
+	assert.Equal(t, 0, len(list))
+}
+
+func contentChangesTest(t *testing.T, db library.Repo, user library.User, cursor string, maxBytes int) (library.ArticleList, string, bool) {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/changes?include=contents&cursor=%s&maxBytes=%d", cursor, maxBytes), nil)
+	w := httptest.NewRecorder()
+	fetchChanges(db, 1<<20)(w, req, user)
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result struct {
+		Changes library.ArticleList `json:"changes"`
+		Cursor  string              `json:"cursor"`
+		More    bool                `json:"more"`
+	}
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return result.Changes, result.Cursor, result.More
+}
+
+func TestChangeContents(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	urls := []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"}
+	for _, url := range urls {
+		contents := strings.Repeat("all about "+url+". ", 50)
+		assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: url, Title: url, Contents: contents}))
+	}
+
+	// a small budget gets one article at a time
+	var all library.ArticleList
+	cursor := ""
+	for {
+		list, next, more := contentChangesTest(t, db, user, cursor, 100)
+		assert.Equal(t, 1, len(list))
+		all = append(all, list...)
+		cursor = next
+		if !more {
+			break
+		}
+	}
+	assert.Equal(t, 3, len(all))
+	for i, entry := range all {
+		assert.Equal(t, urls[i], entry.Url)
+		assert.Assert(t, strings.HasPrefix(entry.Contents, "all about "+urls[i]))
+		sum := sha256.Sum256([]byte(entry.Contents))
+		assert.Equal(t, hex.EncodeToString(sum[:]), entry.ContentHash)
+	}
+
+	// contents the client has been sent aren't sent again
+	assert.NilError(t, db.SetArchive(ctx, user, urls[0], true))
+	list, cursor, more := contentChangesTest(t, db, user, cursor, 1<<20)
+	assert.Assert(t, !more)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "", list[0].Contents)
+	assert.Equal(t, all[0].ContentHash, list[0].ContentHash)
+
+	// contents that changed before a page boundary are still sent after it
+	_, err = db.DB().Exec("UPDATE articles SET contents = 'new contents' WHERE url = ?", urls[1])
+	assert.NilError(t, err)
+	assert.NilError(t, db.SetArchive(ctx, user, urls[2], true))
+	assert.NilError(t, db.SetArchive(ctx, user, urls[1], true))
+	list, cursor, more = contentChangesTest(t, db, user, cursor, 100)
+	assert.Assert(t, more)
+	assert.Equal(t, urls[2], list[0].Url)
+	assert.Equal(t, "", list[0].Contents)
+	list, _, more = contentChangesTest(t, db, user, cursor, 100)
+	assert.Assert(t, !more)
+	assert.Equal(t, urls[1], list[0].Url)
+	assert.Equal(t, "new contents", list[0].Contents)
+
+	// another user adding an existing article gets its contents
+	other := library.User("other@example.com")
+	assert.NilError(t, db.AddToLibrary(ctx, other, urls[0]))
+	list, _, _ = contentChangesTest(t, db, other, "", 1<<20)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Contents != "")
+}
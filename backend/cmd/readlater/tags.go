@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Tidies the tags given by a client or an importer. Slashes separate the
+// folders in a tag's name, so empty folders are dropped. Tags may not
+// contain control characters.
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	for _, tag := range tags {
+		var parts []string
+		for _, part := range strings.Split(tag, "/") {
+			part = strings.TrimSpace(part)
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		tag = strings.Join(parts, "/")
+		if strings.IndexFunc(tag, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("invalid tag: %q", tag)
+		}
+		result = append(result, tag)
+	}
+	return result, nil
+}
+
+type tagsRequest struct {
+	Url  string   `json:"url"`
+	Tags []string `json:"tags"`
+}
+
+func decodeTagsRequest(w http.ResponseWriter, r *http.Request) (tagsRequest, bool) {
+	var req tagsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+		return req, false
+	}
+	if req.Url == "" {
+		logError(w, "No URL provided", http.StatusBadRequest)
+		return req, false
+	}
+	req.Tags, err = normalizeTags(req.Tags)
+	if err != nil {
+		logError(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func addTags(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		req, ok := decodeTagsRequest(w, r)
+		if !ok {
+			return
+		}
+		found, err := db.AddTags(r.Context(), user, req.Url, req.Tags)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error adding tags: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			logError(w, fmt.Sprintf("Not in library: %s", req.Url), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func removeTags(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		req, ok := decodeTagsRequest(w, r)
+		if !ok {
+			return
+		}
+		err := db.RemoveTags(r.Context(), user, req.Url, req.Tags)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error removing tags: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func listTags(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		tags, err := db.Tags(r.Context(), user)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error listing tags: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	}
+}
+
+// Lists the articles with a tag. Listing a folder lists the articles in the
+// folders inside it too.
+func fetchTagged(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		query := r.URL.Query()
+		tags, err := normalizeTags([]string{query.Get("tag")})
+		if err != nil || len(tags) == 0 {
+			logError(w, "No tag provided", http.StatusBadRequest)
+			return
+		}
+		count := 20
+		if countStr := query.Get("count"); countStr != "" {
+			count, err = strconv.Atoi(countStr)
+			if err != nil {
+				logError(w, fmt.Sprintf("Invalid count specification: %s", countStr), http.StatusBadRequest)
+				return
+			}
+		}
+		list, err := db.Tagged(r.Context(), user, tags[0], count)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error fetching tagged articles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = library.ArticleList{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
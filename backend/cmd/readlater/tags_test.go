@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func tagsTest(t *testing.T, handler AuthHandlerFunc, user library.User, url string, tags ...string) int {
+	data, err := json.Marshal(tagsRequest{Url: url, Tags: tags})
+	assert.NilError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/addTags", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	handler(w, req, user)
+	return w.Result().StatusCode
+}
+
+func taggedTest(t *testing.T, db library.Repo, user library.User, tag string) []string {
+	req := httptest.NewRequest(http.MethodGet, "/api/tagged?tag="+tag, nil)
+	w := httptest.NewRecorder()
+	fetchTagged(db)(w, req, user)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var list library.ArticleList
+	assert.NilError(t, json.NewDecoder(w.Result().Body).Decode(&list))
+	var result []string
+	for _, entry := range list {
+		result = append(result, entry.Url)
+	}
+	return result
+}
+
+func TestTags(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	other := library.User("other@example.com")
+
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/a", Title: "A", Contents: "aardvarks"}, "2024-01-01 00:00:00"))
+	assert.NilError(t, db.InsertWithTimestamp(ctx, user, &library.Article{Url: "https://example.com/b", Title: "B", Contents: "baboons"}, "2024-01-02 00:00:00"))
+	assert.NilError(t, db.AddToLibrary(ctx, other, "https://example.com/a"))
+
+	assert.Equal(t, http.StatusOK, tagsTest(t, addTags(db), user, "https://example.com/a", "Work", " reading / later/", ""))
+	assert.Equal(t, http.StatusOK, tagsTest(t, addTags(db), user, "https://example.com/b", "work/projects"))
+	assert.Equal(t, http.StatusOK, tagsTest(t, addTags(db), other, "https://example.com/a", "zoology"))
+	// tags can only go on articles in the library
+	assert.Equal(t, http.StatusNotFound, tagsTest(t, addTags(db), other, "https://example.com/b", "zoology"))
+	assert.Equal(t, http.StatusBadRequest, tagsTest(t, addTags(db), user, "https://example.com/a", "bad\ntag"))
+
+	list, _, err := db.ListArticles(ctx, user, library.ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"https://example.com/b", "https://example.com/a"}, []string{list[0].Url, list[1].Url})
+	assert.DeepEqual(t, []string{"work/projects"}, list[0].Tags)
+	assert.DeepEqual(t, []string{"reading/later", "Work"}, list[1].Tags)
+
+	tags, err := db.Tags(ctx, user)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []library.TagCount{{Name: "reading/later", Count: 1}, {Name: "Work", Count: 1}, {Name: "work/projects", Count: 1}}, tags)
+
+	// a folder lists the articles in the folders inside it, ignoring case
+	assert.DeepEqual(t, []string{"https://example.com/b", "https://example.com/a"}, taggedTest(t, db, user, "work"))
+	assert.DeepEqual(t, []string{"https://example.com/b"}, taggedTest(t, db, user, "WORK/projects"))
+	assert.DeepEqual(t, []string{"https://example.com/a"}, taggedTest(t, db, user, "reading"))
+	assert.Equal(t, 0, len(taggedTest(t, db, user, "wor")))
+	assert.Equal(t, 0, len(taggedTest(t, db, user, "zoology")))
+
+	// tags are searched along with the contents, but only the user's own
+	found, err := db.Search(ctx, user, "projects")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "https://example.com/b", found[0].Url)
+	found, err = db.Search(ctx, user, "zoology")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(found))
+	found, err = db.Search(ctx, other, "zoology")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.DeepEqual(t, []string{"zoology"}, found[0].Tags)
+
+	// removing a tag is a change to the library entry
+	seq, err := db.ChangeSequence(ctx)
+	assert.NilError(t, err)
+	data, err := json.Marshal(tagsRequest{Url: "https://example.com/b", Tags: []string{"work/projects"}})
+	assert.NilError(t, err)
+	w := httptest.NewRecorder()
+	removeTags(db)(w, httptest.NewRequest(http.MethodPost, "/api/removeTags", bytes.NewReader(data)), user)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	changes, _, err := db.GetChangesAfter(ctx, user, seq)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "https://example.com/b", changes[0].Url)
+	assert.DeepEqual(t, []string{}, changes[0].Tags)
+	found, err = db.Search(ctx, user, "projects")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(found))
+
+	// unused tags are forgotten
+	tags, err = db.Tags(ctx, user)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []library.TagCount{{Name: "reading/later", Count: 1}, {Name: "Work", Count: 1}}, tags)
+
+	// tags follow renamed articles and leave with deleted ones
+	_, err = db.DB().Exec("UPDATE articles SET url = 'https://example.com/aa' WHERE url = 'https://example.com/a'")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"https://example.com/aa"}, taggedTest(t, db, user, "work"))
+	found, err = db.Search(ctx, other, "zoology")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "https://example.com/aa", found[0].Url)
+	_, err = db.DB().Exec("DELETE FROM articles WHERE url = 'https://example.com/aa'")
+	assert.NilError(t, err)
+	var count int
+	assert.NilError(t, db.DB().QueryRow("SELECT count(*) FROM articleTags").Scan(&count))
+	assert.Equal(t, 0, count)
+	assert.NilError(t, db.DB().QueryRow("SELECT count(*) FROM tagFts").Scan(&count))
+	assert.Equal(t, 0, count)
+}
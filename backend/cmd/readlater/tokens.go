@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Scopes limit what an API token may be used for. Sessions are not limited.
+const (
+	scopeRead  = "read"  // list, search and read articles
+	scopeAdd   = "add"   // add articles
+	scopeWrite = "write" // change the state of articles
+)
+
+var allScopes = []string{scopeRead, scopeAdd, scopeWrite}
+
+type scopesKey struct{}
+
+// Records in the request context that it was authenticated with an API
+// token carrying the given scopes
+func withScopes(r *http.Request, scopes []string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scopesKey{}, scopes))
+}
+
+// Returns the scopes of the token that authenticated the request, and false
+// if it was not authenticated with a token
+func requestScopes(r *http.Request) ([]string, bool) {
+	scopes, ok := r.Context().Value(scopesKey{}).([]string)
+	return scopes, ok
+}
+
+// Rejects requests made with an API token that lacks the scope
+func requireScope(scope string, next AuthHandlerFunc) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		if scopes, ok := requestScopes(r); ok && !slices.Contains(scopes, scope) {
+			logError(w, fmt.Sprintf("API token lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+		next(w, r, user)
+	}
+}
+
+// Rejects requests made with an API token, so that a token can't be used
+// to mint or revoke tokens
+func requireSession(next AuthHandlerFunc) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		if _, ok := requestScopes(r); ok {
+			logError(w, "API tokens can't manage API tokens", http.StatusForbidden)
+			return
+		}
+		next(w, r, user)
+	}
+}
+
+func createToken(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			logError(w, fmt.Sprintf("JSON decode error: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = allScopes
+		}
+		for _, scope := range req.Scopes {
+			if !slices.Contains(allScopes, scope) {
+				logError(w, fmt.Sprintf("Invalid scope: %s", scope), http.StatusBadRequest)
+				return
+			}
+		}
+		id, token, err := db.CreateToken(r.Context(), user, req.Name, req.Scopes)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error creating token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			Id     int64    `json:"id"`
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+			Token  string   `json:"token"`
+		}{id, req.Name, req.Scopes, token})
+	}
+}
+
+func listTokens(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		tokens, err := db.Tokens(r.Context(), user)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error listing tokens: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+func revokeToken(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			logError(w, fmt.Sprintf("Invalid token id: %s", r.PathValue("id")), http.StatusBadRequest)
+			return
+		}
+		found, err := db.DeleteToken(r.Context(), user, id)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error revoking token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			logError(w, fmt.Sprintf("No such token: %d", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func TestApiTokens(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	auth := newAuthenticator(db, nil, time.Hour, nil)
+	user := library.User("test@example.com")
+
+	// mint an add-only token from a session
+	data, err := json.Marshal(map[string]any{"name": "bookmarklet", "scopes": []string{scopeAdd}})
+	assert.NilError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	requireSession(createToken(db))(w, req, user)
+	resp := w.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created struct {
+		Id    int64  `json:"id"`
+		Token string `json:"token"`
+	}
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Assert(t, created.Token != "")
+
+	// unknown scopes are refused
+	data, err = json.Marshal(map[string]any{"name": "bad", "scopes": []string{"admin"}})
+	assert.NilError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewReader(data))
+	w = httptest.NewRecorder()
+	createToken(db)(w, req, user)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// the token is listed but not revealed
+	req = httptest.NewRequest(http.MethodGet, "/api/tokens", nil)
+	w = httptest.NewRecorder()
+	listTokens(db)(w, req, user)
+	var tokens []library.ApiToken
+	assert.NilError(t, json.NewDecoder(w.Result().Body).Decode(&tokens))
+	assert.Equal(t, 1, len(tokens))
+	assert.Equal(t, "bookmarklet", tokens[0].Name)
+	assert.DeepEqual(t, []string{scopeAdd}, tokens[0].Scopes)
+
+	bearer := func(handler AuthHandlerFunc, method string, body []byte) int {
+		req := httptest.NewRequest(method, "/api/whatever", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		w := httptest.NewRecorder()
+		auth.middleware()(handler)(w, req)
+		return w.Result().StatusCode
+	}
+	summary, err := json.Marshal(map[string]string{"url": urls[0]})
+	assert.NilError(t, err)
+
+	// the token may add articles but not read them
+	assert.Equal(t, http.StatusOK, bearer(requireScope(scopeAdd, summarize(mockSummarizer, db, mockFetcher, nil)), http.MethodPost, summary))
+	assert.Equal(t, http.StatusForbidden, bearer(requireScope(scopeRead, fetchRecents(db)), http.MethodGet, nil))
+	list, err := db.Recents(context.Background(), user, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	// nor can it mint more tokens
+	assert.Equal(t, http.StatusForbidden, bearer(requireSession(createToken(db)), http.MethodPost, []byte(`{}`)))
+
+	// once revoked it's useless
+	req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/tokens/%d", created.Id), nil)
+	req.SetPathValue("id", fmt.Sprint(created.Id))
+	w = httptest.NewRecorder()
+	revokeToken(db)(w, req, user)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, bearer(requireScope(scopeAdd, summarize(mockSummarizer, db, mockFetcher, nil)), http.MethodPost, summary))
+
+	// another user's token can't be revoked
+	id, _, err := db.CreateToken(context.Background(), library.User("other@example.com"), "theirs", allScopes)
+	assert.NilError(t, err)
+	req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/tokens/%d", id), nil)
+	req.SetPathValue("id", fmt.Sprint(id))
+	w = httptest.NewRecorder()
+	revokeToken(db)(w, req, user)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
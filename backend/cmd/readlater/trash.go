@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// Puts an article in the trash. It disappears from the user's lists and
+// search at once, and clients syncing changes are told it was deleted, but
+// it can be restored until the trash is purged.
+func trashArticle(db library.Repo, locate articleLocator) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		url, ok := locate(w, r, db, user)
+		if !ok {
+			return
+		}
+		found, err := db.Trash(r.Context(), user, url)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error deleting article: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			logError(w, fmt.Sprintf("Not in library: %s", url), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func restoreArticle(db library.Repo, locate articleLocator) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		url, ok := locate(w, r, db, user)
+		if !ok {
+			return
+		}
+		found, err := db.Restore(r.Context(), user, url)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error restoring article: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			logError(w, fmt.Sprintf("Not in trash: %s", url), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func fetchTrash(db library.Repo) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		var err error
+		count := 50
+		countStr, ok := r.URL.Query()["count"]
+		if ok {
+			count, err = strconv.Atoi(countStr[0])
+			if err != nil {
+				logError(w, fmt.Sprintf("Invalid count specification: %s", countStr[0]), http.StatusBadRequest)
+				return
+			}
+		}
+		list, err := db.Trashed(r.Context(), user, count)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error fetching trash: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = library.ArticleList{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
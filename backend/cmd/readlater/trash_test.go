@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func TestTrash(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := library.User("test@example.com")
+	other := library.User("other@example.com")
+	a := "https://example.com/a"
+	b := "https://example.com/b"
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: a, Title: "A", Contents: "junk"}))
+	assert.NilError(t, db.Insert(ctx, user, &library.Article{Url: b, Title: "B", Contents: "keeper"}))
+	assert.NilError(t, db.AddToLibrary(ctx, other, a))
+	_, err = db.AddTags(ctx, user, a, []string{"oops"})
+	assert.NilError(t, err)
+	assert.NilError(t, db.SetArchive(ctx, user, a, true))
+	_, cursor, err := db.GetChangesAfter(ctx, user, 0)
+	assert.NilError(t, err)
+
+	do := func(handler AuthHandlerFunc, method string, path string) *http.Response {
+		req := httptest.NewRequest(method, path+"?url="+url.QueryEscape(a), nil)
+		w := httptest.NewRecorder()
+		handler(w, req, user)
+		return w.Result()
+	}
+	visible := func() int {
+		list, _, err := db.ListArticles(ctx, user, library.ListQuery{Sort: "created"})
+		assert.NilError(t, err)
+		return len(list)
+	}
+
+	assert.Equal(t, http.StatusOK, do(trashArticle(db, articleFromQuery), http.MethodDelete, "/api/article").StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(trashArticle(db, articleFromQuery), http.MethodDelete, "/api/article").StatusCode)
+
+	// it is gone from the user's lists, search and tags, but not other users'
+	assert.Equal(t, 1, visible())
+	list, err := db.Search(ctx, user, "junk")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(list))
+	list, err = db.Tagged(ctx, user, "oops", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(list))
+	tags, err := db.Tags(ctx, user)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, tags[0].Count)
+	list, err = db.Search(ctx, other, "junk")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	// clients are told it was deleted
+	changes, cursor, err := db.GetChangesAfter(ctx, user, cursor)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, a, changes[0].Url)
+	assert.Assert(t, changes[0].Deleted)
+
+	// the trash lists it
+	resp := do(fetchTrash(db), http.MethodGet, "/api/trash")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var trash library.ArticleList
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&trash))
+	assert.Equal(t, 1, len(trash))
+	assert.Equal(t, a, trash[0].Url)
+	assert.Assert(t, trash[0].Trashed != "")
+
+	// it can't be changed while it is there, and clients hear nothing more
+	assert.NilError(t, db.SetArchive(ctx, user, a, false))
+	assert.NilError(t, db.MarkRead(ctx, user, a))
+	results, err := db.ApplyMutations(ctx, user, "phone", []library.Mutation{{Url: a, Field: "unread", Value: false, Timestamp: time.Now().UnixMilli()}})
+	assert.NilError(t, err)
+	assert.Equal(t, "not in library", results[0].Error)
+	changes, _, err = db.GetChangesAfter(ctx, user, cursor)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(changes))
+
+	// restoring it brings it back as it was, contents and all for clients
+	assert.Equal(t, http.StatusOK, do(restoreArticle(db, articleFromQuery), http.MethodPost, "/api/article/restore").StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(restoreArticle(db, articleFromQuery), http.MethodPost, "/api/article/restore").StatusCode)
+	assert.Equal(t, 2, visible())
+	list, err = db.Tagged(ctx, user, "oops", 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Archived)
+	assert.Assert(t, list[0].Unread)
+	changes, _, _, err = db.GetContentChangesAfter(ctx, user, cursor, cursor, 1<<20)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Assert(t, !changes[0].Deleted)
+	assert.Equal(t, "junk", changes[0].Contents)
+
+	// saving it again takes it out of the trash too
+	_, err = db.Trash(ctx, user, a)
+	assert.NilError(t, err)
+	assert.NilError(t, db.AddToLibrary(ctx, user, a))
+	assert.Equal(t, 2, visible())
+
+	// the trash is only purged once the retention period is up
+	_, err = db.Trash(ctx, user, a)
+	assert.NilError(t, err)
+	_, cursor, err = db.GetChangesAfter(ctx, user, 0)
+	assert.NilError(t, err)
+	count, err := db.PurgeTrash(ctx, time.Hour)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(0), count)
+	_, err = db.DB().Exec("UPDATE library SET trashed = datetime('now', '-2 hours') WHERE trashed IS NOT NULL")
+	assert.NilError(t, err)
+	count, err = db.PurgeTrash(ctx, time.Hour)
+	assert.NilError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, http.StatusNotFound, do(restoreArticle(db, articleFromQuery), http.MethodPost, "/api/article/restore").StatusCode)
+	changes, _, err = db.GetChangesAfter(ctx, user, cursor)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(changes))
+	assert.Assert(t, changes[0].Deleted)
+	assert.Equal(t, int64(0), changes[0].Id)
+
+	// other users keep the article
+	_, ok := db.LibraryArticle(ctx, other, a)
+	assert.Assert(t, ok)
+}
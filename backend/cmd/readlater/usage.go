@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+)
+
+// The price of a model's tokens, in dollars per million
+type tokenPrice struct {
+	In  float64
+	Out float64
+}
+
+// Prices keyed by model. The entry for "*", if any, prices calls to models
+// that aren't listed.
+type priceTable map[string]tokenPrice
+
+// Parses prices given as model:in/out, e.g. gpt-4o-mini:0.15/0.60
+func parsePrices(spec map[string]string) (priceTable, error) {
+	prices := priceTable{}
+	for model, price := range spec {
+		in, out, ok := strings.Cut(price, "/")
+		if !ok {
+			return nil, fmt.Errorf("price for %s should be input/output: %s", model, price)
+		}
+		var p tokenPrice
+		var err error
+		if p.In, err = strconv.ParseFloat(in, 64); err != nil {
+			return nil, fmt.Errorf("invalid input price for %s: %s", model, in)
+		}
+		if p.Out, err = strconv.ParseFloat(out, 64); err != nil {
+			return nil, fmt.Errorf("invalid output price for %s: %s", model, out)
+		}
+		prices[model] = p
+	}
+	return prices, nil
+}
+
+func (prices priceTable) cost(model string, tokensIn int, tokensOut int) float64 {
+	p, ok := prices[model]
+	if !ok {
+		p = prices["*"]
+	}
+	return (float64(tokensIn)*p.In + float64(tokensOut)*p.Out) / 1e6
+}
+
+type usageTotals struct {
+	Calls     int     `json:"calls"`
+	TokensIn  int     `json:"tokensIn"`
+	TokensOut int     `json:"tokensOut"`
+	LengthIn  int     `json:"lengthIn"`
+	LengthOut int     `json:"lengthOut"`
+	Cost      float64 `json:"cost"`
+}
+
+func (t *usageTotals) add(row library.UsageRow, cost float64) {
+	t.Calls += row.Calls
+	t.TokensIn += row.TokensIn
+	t.TokensOut += row.TokensOut
+	t.LengthIn += row.LengthIn
+	t.LengthOut += row.LengthOut
+	t.Cost += cost
+}
+
+type usagePeriod struct {
+	Period string `json:"period"`
+	usageTotals
+}
+
+type usageDomain struct {
+	Domain string `json:"domain"`
+	usageTotals
+}
+
+type usageReport struct {
+	Period  string        `json:"period"` // day, week or month
+	Since   string        `json:"since,omitempty"`
+	Periods []usagePeriod `json:"periods"`
+	Domains []usageDomain `json:"domains"`
+	Total   usageTotals   `json:"total"`
+}
+
+var usagePeriods = []string{"day", "week", "month"}
+
+// Totals the usage since the given date by period and by domain. Periods
+// are listed most recent first and domains most expensive first.
+func buildUsageReport(ctx context.Context, db library.Repo, prices priceTable, period string, since string) (*usageReport, error) {
+	rows, err := db.UsageRows(ctx, period, since)
+	if err != nil {
+		return nil, err
+	}
+	report := usageReport{Period: period, Since: since, Periods: []usagePeriod{}, Domains: []usageDomain{}}
+	periods := map[string]*usageTotals{}
+	domains := map[string]*usageTotals{}
+	for _, row := range rows {
+		cost := prices.cost(row.Model, row.TokensIn, row.TokensOut)
+		if periods[row.Period] == nil {
+			periods[row.Period] = &usageTotals{}
+		}
+		periods[row.Period].add(row, cost)
+		domain := usageDomainOf(row.Url)
+		if domains[domain] == nil {
+			domains[domain] = &usageTotals{}
+		}
+		domains[domain].add(row, cost)
+		report.Total.add(row, cost)
+	}
+	for period, totals := range periods {
+		report.Periods = append(report.Periods, usagePeriod{period, *totals})
+	}
+	slices.SortFunc(report.Periods, func(a, b usagePeriod) int {
+		return strings.Compare(b.Period, a.Period)
+	})
+	for domain, totals := range domains {
+		report.Domains = append(report.Domains, usageDomain{domain, *totals})
+	}
+	slices.SortFunc(report.Domains, func(a, b usageDomain) int {
+		return cmp.Or(
+			cmp.Compare(b.Cost, a.Cost),
+			cmp.Compare(b.TokensIn+b.TokensOut, a.TokensIn+a.TokensOut),
+			strings.Compare(a.Domain, b.Domain))
+	})
+	return &report, nil
+}
+
+func usageDomainOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return rawURL
+	}
+	return strings.TrimPrefix(parsed.Hostname(), "www.")
+}
+
+// Lets only the admins through, since usage is reported for the whole
+// server and shows what every user has been reading
+func requireAdmin(admins []string, next AuthHandlerFunc) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		if !slices.Contains(admins, string(user)) {
+			logError(w, fmt.Sprintf("%s is not an admin", user), http.StatusForbidden)
+			return
+		}
+		next(w, r, user)
+	}
+}
+
+func fetchUsage(db library.Repo, prices priceTable) AuthHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, user library.User) {
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			period = "day"
+		}
+		if !slices.Contains(usagePeriods, period) {
+			logError(w, fmt.Sprintf("Invalid period: %s", period), http.StatusBadRequest)
+			return
+		}
+		since := r.URL.Query().Get("since")
+		if since != "" {
+			if _, err := time.Parse(time.DateOnly, since); err != nil {
+				logError(w, fmt.Sprintf("Invalid since date: %s", since), http.StatusBadRequest)
+				return
+			}
+		}
+		report, err := buildUsageReport(r.Context(), db, prices, period, since)
+		if err != nil {
+			logError(w, fmt.Sprintf("Error reporting usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// Prints the usage report. Run as: readlater usage [-period day|week|month] [-since yyyy-mm-dd]
+func usageCommand(db library.Repo, prices priceTable, args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	period := flags.String("period", "day", "group usage by day, week or month")
+	since := flags.String("since", "", "only report usage on or after this date (yyyy-mm-dd)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !slices.Contains(usagePeriods, *period) {
+		return fmt.Errorf("invalid period: %s", *period)
+	}
+	if *since != "" {
+		if _, err := time.Parse(time.DateOnly, *since); err != nil {
+			return fmt.Errorf("invalid since date: %s", *since)
+		}
+	}
+	report, err := buildUsageReport(context.Background(), db, prices, *period, *since)
+	if err != nil {
+		return err
+	}
+	return printUsageReport(os.Stdout, report)
+}
+
+// Prints the report as tables
+func printUsageReport(out io.Writer, report *usageReport) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	row := func(name string, t usageTotals) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%.4f\t\n",
+			name, t.Calls, t.TokensIn, t.TokensOut, t.LengthIn, t.LengthOut, t.Cost)
+	}
+	fmt.Fprintf(tw, "%s\tcalls\ttokensIn\ttokensOut\tlengthIn\tlengthOut\tcost\t\n", report.Period)
+	for _, p := range report.Periods {
+		row(p.Period, p.usageTotals)
+	}
+	row("total", report.Total)
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "domain\tcalls\ttokensIn\ttokensOut\tlengthIn\tlengthOut\tcost\t\n")
+	for _, d := range report.Domains {
+		row(d.Domain, d.usageTotals)
+	}
+	return tw.Flush()
+}
+
+// Returns the start of the current calendar month, in UTC
+func startOfMonth(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Uses the fallback summarizer instead once the tokens used this month
+// reach the budget
+func withTokenBudget(summarizer library.SummarizeFunc, fallback library.SummarizeFunc, db library.Repo, budget int) library.SummarizeFunc {
+	return func(ctx context.Context, url string, article []byte) (library.Summary, error) {
+		used, err := db.TokensSince(ctx, startOfMonth(time.Now()))
+		if err != nil {
+			return library.Summary{}, err
+		}
+		if used >= budget {
+			log.Printf("Monthly token budget of %d used up, not summarizing %s", budget, url)
+			return fallback(ctx, url, article)
+		}
+		return summarizer(ctx, url, article)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/internal/library"
+	"gotest.tools/assert"
+)
+
+func usageTest(t *testing.T, db library.Repo, prices priceTable, query string) usageReport {
+	req := httptest.NewRequest(http.MethodGet, "/usage"+query, nil)
+	w := httptest.NewRecorder()
+	fetchUsage(db, prices)(w, req, library.User("test@example.com"))
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var report usageReport
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&report))
+	return report
+}
+
+func TestUsageReport(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	_, err = db.DB().Exec(`INSERT INTO usage (timestamp, url, model, lengthIn, lengthOut, tokensIn, tokensOut) VALUES
+		('2025-06-02 10:00:00', 'https://www.example.com/a', 'small', 4000, 100, 1000, 20),
+		('2025-06-04 10:00:00', 'https://example.com/b', 'small', 8000, 200, 2000, 40),
+		('2025-06-09 10:00:00', 'https://news.example.org/c', 'large', 4000, 100, 1000, 20),
+		('2025-07-01 10:00:00', 'https://news.example.org/d', NULL, 4000, 100, 1000, 20)`)
+	assert.NilError(t, err)
+	prices, err := parsePrices(map[string]string{"small": "1/2", "large": "10/20", "*": "100/200"})
+	assert.NilError(t, err)
+
+	report := usageTest(t, db, prices, "?period=week")
+	assert.Equal(t, "week", report.Period)
+	assert.Equal(t, 3, len(report.Periods))
+	assert.Equal(t, "2025-06-30", report.Periods[0].Period)
+	assert.Equal(t, "2025-06-09", report.Periods[1].Period)
+	assert.Equal(t, "2025-06-02", report.Periods[2].Period)
+	assert.Equal(t, 2, report.Periods[2].Calls)
+	assert.Equal(t, 3000, report.Periods[2].TokensIn)
+	assert.Equal(t, 60, report.Periods[2].TokensOut)
+	assert.Equal(t, 12000, report.Periods[2].LengthIn)
+	assert.Equal(t, 300, report.Periods[2].LengthOut)
+	assert.Equal(t, 4, report.Total.Calls)
+	assert.Equal(t, 5000, report.Total.TokensIn)
+
+	// domains are most expensive first, and unlisted models use the * price
+	assert.Equal(t, 2, len(report.Domains))
+	assert.Equal(t, "news.example.org", report.Domains[0].Domain)
+	assert.Equal(t, "example.com", report.Domains[1].Domain)
+	assert.Equal(t, 2, report.Domains[1].Calls)
+	assert.Assert(t, report.Domains[1].Cost > 0.00311 && report.Domains[1].Cost < 0.00313)
+	assert.Assert(t, report.Domains[0].Cost > 0.1143 && report.Domains[0].Cost < 0.1145)
+
+	report = usageTest(t, db, prices, "?period=month&since=2025-06-05")
+	assert.Equal(t, 2, len(report.Periods))
+	assert.Equal(t, "2025-07", report.Periods[0].Period)
+	assert.Equal(t, "2025-06", report.Periods[1].Period)
+	assert.Equal(t, 1, report.Periods[1].Calls)
+
+	// the default is by day
+	report = usageTest(t, db, prices, "")
+	assert.Equal(t, 4, len(report.Periods))
+
+	req := httptest.NewRequest(http.MethodGet, "/usage?period=fortnight", nil)
+	w := httptest.NewRecorder()
+	fetchUsage(db, prices)(w, req, library.User("test@example.com"))
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	var out bytes.Buffer
+	assert.NilError(t, printUsageReport(&out, &report))
+	assert.Assert(t, strings.Contains(out.String(), "news.example.org"))
+	assert.Assert(t, strings.Contains(out.String(), "2025-06-09"))
+
+	// only admins see it
+	req = httptest.NewRequest(http.MethodGet, "/usage", nil)
+	w = httptest.NewRecorder()
+	requireAdmin([]string{"admin@example.com"}, fetchUsage(db, prices))(w, req, library.User("test@example.com"))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+	w = httptest.NewRecorder()
+	requireAdmin([]string{"admin@example.com"}, fetchUsage(db, prices))(w, req, library.User("admin@example.com"))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	// nor does anyone when nobody signs in
+	w = httptest.NewRecorder()
+	requireAdmin([]string{"admin@example.com"}, fetchUsage(db, prices))(w, req, library.User(""))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	_, err = parsePrices(map[string]string{"small": "1"})
+	assert.ErrorContains(t, err, "input/output")
+}
+
+func TestTokenBudget(t *testing.T) {
+	db, err := library.NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+
+	calls := 0
+	expensive := func(ctx context.Context, url string, article []byte) (library.Summary, error) {
+		calls++
+		err := db.Usage(ctx, library.Usage{Url: url, TokensIn: 600, TokensOut: 100})
+		return library.Summary{Contents: "expensive", Tldr: "tl;dr"}, err
+	}
+	summarizer := withTokenBudget(expensive, mockSummarizer, db, 1000)
+
+	// last month's usage doesn't count
+	lastMonth := startOfMonth(time.Now()).Add(-time.Hour).Format("2006-01-02 15:04:05")
+	_, err = db.DB().Exec("INSERT INTO usage (timestamp, url, tokensIn, tokensOut) VALUES (?, 'x', 5000, 0)", lastMonth)
+	assert.NilError(t, err)
+
+	result, err := summarizer(ctx, "https://example.com/1", []byte("one"))
+	assert.NilError(t, err)
+	assert.Equal(t, "tl;dr", result.Tldr)
+	result, err = summarizer(ctx, "https://example.com/2", []byte("two"))
+	assert.NilError(t, err)
+	assert.Equal(t, "tl;dr", result.Tldr)
+
+	// the budget is now used up
+	result, err = summarizer(ctx, "https://example.com/3", []byte("three"))
+	assert.NilError(t, err)
+	assert.Equal(t, "", result.Tldr)
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Reads the JSON that Wallabag exports. It carries the content of each
+// article, which is imported as it was saved rather than fetched again.
+type wallabagImporter struct{}
+
+type wallabagEntry struct {
+	Title     string   `json:"title"`
+	Url       string   `json:"url"`
+	Content   string   `json:"content"`
+	Tags      []string `json:"tags"`
+	CreatedAt string   `json:"created_at"`
+	// 0 or 1, or a boolean in older versions
+	IsArchived json.RawMessage `json:"is_archived"`
+}
+
+func (wallabagImporter) Name() string { return "wallabag" }
+
+func (wallabagImporter) Detect(data []byte) bool {
+	return jsonArrayHas(data, "is_archived")
+}
+
+func (wallabagImporter) Parse(data []byte) ([]record, error) {
+	var entries []wallabagEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	var records []record
+	for _, entry := range entries {
+		if entry.Url == "" {
+			continue
+		}
+		r := record{
+			Title:    entry.Title,
+			URL:      entry.Url,
+			Tags:     entry.Tags,
+			Archived: string(entry.IsArchived) == "1" || string(entry.IsArchived) == "true",
+		}
+		if entry.Content != "" {
+			r.Html = []byte(entry.Content)
+		}
+		// Offsets are written without a colon
+		if created, err := time.Parse("2006-01-02T15:04:05-0700", entry.CreatedAt); err == nil {
+			r.TimeAdded = created.Unix()
+		} else if created, err := time.Parse(time.RFC3339, entry.CreatedAt); err == nil {
+			r.TimeAdded = created.Unix()
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
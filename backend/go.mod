@@ -6,6 +6,7 @@ require (
 	github.com/JohannesKaufmann/html-to-markdown v1.6.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/mattn/go-sqlite3 v1.14.28
+	github.com/yuin/goldmark v1.8.2
 	golang.org/x/net v0.40.0
 	gotest.tools v2.2.0+incompatible
 )
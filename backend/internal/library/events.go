@@ -0,0 +1,65 @@
+package library
+
+import "sync"
+
+// broadcaster tells the event streams of a user that their library has
+// changed. Each subscription buffers a single pending notification, so a
+// slow stream never holds up a write and notifications that arrive while
+// one is pending are merged into it; streams catch up by asking for every
+// change since the last one they sent.
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[User]map[chan struct{}]struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subscribers: map[User]map[chan struct{}]struct{}{}}
+}
+
+// Returns a channel that receives a value after the user's library changes,
+// and a function to call when done with it
+func (b *broadcaster) Subscribe(user User) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[user] == nil {
+		b.subscribers[user] = map[chan struct{}]struct{}{}
+	}
+	b.subscribers[user][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[user], ch)
+		if len(b.subscribers[user]) == 0 {
+			delete(b.subscribers, user)
+		}
+	}
+}
+
+// Tells the user's subscribers that their library changed
+func (b *broadcaster) Notify(user User) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[user] {
+		poke(ch)
+	}
+}
+
+// Tells every subscriber to check for changes, for changes to articles that
+// may be in anyone's library
+func (b *broadcaster) NotifyAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, chans := range b.subscribers {
+		for ch := range chans {
+			poke(ch)
+		}
+	}
+}
+
+func poke(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
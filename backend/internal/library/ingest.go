@@ -0,0 +1,208 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rcbilson/readlater/www"
+)
+
+// The status of an article's contents
+const (
+	StatusPending  = "pending"  // waiting to be fetched
+	StatusFetching = "fetching" // being fetched by a worker
+	StatusFailed   = "failed"   // gave up fetching, see fetchError
+	StatusReady    = "ready"
+)
+
+var ErrFetchFailed = errors.New("error retrieving article")
+
+// FetchArticle retrieves the page at rawURL and converts it into an article
+// to be stored under its canonical URL, known also by rawURL and the urls
+// it redirected through. If the page turns out to be one we already have
+// under one of those, the stored article is returned instead and existing
+// is true.
+func FetchArticle(ctx context.Context, db Repo, summarizer SummarizeFunc, fetcher www.FetcherFunc, rawURL string, titleHint string) (art *Article, existing bool, err error) {
+	log.Println("fetching article", rawURL)
+	ctx, redirects := www.RecordRedirects(ctx)
+	html, finalURL, err := fetcher(ctx, rawURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+	return convertArticle(ctx, db, summarizer, rawURL, redirects(), finalURL, html, titleHint)
+}
+
+// ConvertArticle is FetchArticle for a page that has already been fetched
+// from rawURL, ending up at finalURL
+func ConvertArticle(ctx context.Context, db Repo, summarizer SummarizeFunc, rawURL string, finalURL string, html []byte, titleHint string) (art *Article, existing bool, err error) {
+	return convertArticle(ctx, db, summarizer, rawURL, nil, finalURL, html, titleHint)
+}
+
+func convertArticle(ctx context.Context, db Repo, summarizer SummarizeFunc, rawURL string, redirects []string, finalURL string, html []byte, titleHint string) (art *Article, existing bool, err error) {
+	// Canonicalize URL, taking the page's word for it if it says what its
+	// canonical url is
+	canonicalURL, err := db.rules.CanonicalizePage(finalURL, html)
+	if err != nil {
+		log.Printf("Error canonicalizing URL %s: %v", finalURL, err)
+		canonicalURL = finalURL // fallback to original URL
+	}
+	var aliases []string
+	for _, u := range append(append([]string{rawURL}, redirects...), finalURL) {
+		if u != canonicalURL && !slices.Contains(aliases, u) {
+			aliases = append(aliases, u)
+		}
+	}
+
+	// Check if we already have this article under any of its urls. The raw
+	// url may be the pending article being fetched, which doesn't count.
+	for _, u := range append([]string{canonicalURL}, aliases...) {
+		if u == rawURL {
+			continue
+		}
+		if art, ok := db.GetWithoutUpdating(ctx, u); ok && art.Url != rawURL {
+			if err := db.AddAliases(ctx, art.Url, append(aliases, canonicalURL)); err != nil {
+				log.Printf("Error recording aliases of %s: %v", art.Url, err)
+			}
+			return art, true, nil
+		}
+	}
+
+	summary, err := summarizer(ctx, canonicalURL, html)
+	if err != nil {
+		return nil, false, fmt.Errorf("error extracting article text: %w", err)
+	}
+	art = &Article{Url: canonicalURL, Contents: summary.Contents, Summary: summary.Tldr, Status: StatusReady, Aliases: aliases}
+	art.Title = ExtractTitle(&art.Contents, html, finalURL, titleHint)
+	return art, false, nil
+}
+
+type Job struct {
+	Id        int64
+	Url       string
+	User      User
+	TitleHint string
+	Attempts  int
+}
+
+// IngestQueue fetches articles in the background. Jobs are kept in the
+// database so that they survive restarts, and failed fetches are retried
+// with exponential backoff until maxAttempts is reached. Each attempt,
+// summarizing included, is given up once it has taken the timeout.
+type IngestQueue struct {
+	db          Repo
+	summarizer  SummarizeFunc
+	fetcher     www.FetcherFunc
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+	timeout     time.Duration
+	// How often idle workers look for jobs that have come due
+	PollInterval time.Duration
+	wake         chan struct{}
+}
+
+func NewIngestQueue(db Repo, summarizer SummarizeFunc, fetcher www.FetcherFunc, workers int, maxAttempts int, backoff time.Duration, timeout time.Duration) *IngestQueue {
+	return &IngestQueue{
+		db:           db,
+		summarizer:   summarizer,
+		fetcher:      fetcher,
+		workers:      workers,
+		maxAttempts:  maxAttempts,
+		backoff:      backoff,
+		timeout:      timeout,
+		PollInterval: 5 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Run processes jobs until the context is cancelled
+func (q *IngestQueue) Run(ctx context.Context) {
+	// Jobs that were running when we last stopped are up for grabs again
+	if err := q.db.ResetJobs(ctx); err != nil {
+		log.Printf("Error resetting jobs: %v", err)
+	}
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Add a pending article to the user's library and schedule it to be fetched
+func (q *IngestQueue) Enqueue(ctx context.Context, user User, url string, titleHint string) (*Article, error) {
+	art, err := q.db.Enqueue(ctx, user, url, titleHint)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return art, nil
+}
+
+// Schedule an article that failed to fetch to be tried again
+func (q *IngestQueue) Retry(ctx context.Context, user User, url string, titleHint string) (*Article, error) {
+	art, err := q.db.Requeue(ctx, user, url, titleHint)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return art, nil
+}
+
+func (q *IngestQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *IngestQueue) work(ctx context.Context) {
+	for {
+		j, ok, err := q.db.ClaimJob(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error claiming job: %v", err)
+		}
+		if ok {
+			q.process(ctx, j)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+func (q *IngestQueue) process(ctx context.Context, j Job) {
+	fetchCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	art, _, err := FetchArticle(fetchCtx, q.db, q.summarizer, q.fetcher, j.Url, j.TitleHint)
+	if err == nil {
+		err = q.db.CompleteJob(ctx, j, art)
+		if err == nil {
+			return
+		}
+	}
+
+	var retry time.Duration
+	if j.Attempts+1 < q.maxAttempts {
+		retry = q.backoff << j.Attempts
+		log.Printf("Fetching %s failed, retrying in %v: %v", j.Url, retry, err)
+	} else {
+		log.Printf("Fetching %s failed, giving up: %v", j.Url, err)
+	}
+	if err := q.db.FailJob(ctx, j, err.Error(), retry); err != nil {
+		log.Printf("Error recording failure of job %d: %v", j.Id, err)
+	}
+}
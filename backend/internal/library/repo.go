@@ -0,0 +1,1848 @@
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rcbilson/readlater/sqlite"
+)
+
+// The owner of a library, identified by their email address
+type User string
+
+type ArticleEntry struct {
+	// Stays the same when the article moves to another url. Articles
+	// removed from the library for good have none.
+	Id         int64  `json:"id,omitempty"`
+	Title      string `json:"title"`
+	Url        string `json:"url"`
+	HasBody    bool   `json:"hasBody"`
+	Status     string `json:"status"`
+	Unread     bool   `json:"unread"`
+	Archived   bool   `json:"archived"`
+	LastAccess string `json:"lastAccess"`
+	Deleted    bool   `json:"deleted,omitempty"`
+	// When the article was put in the trash, only in the list of the trash
+	Trashed string `json:"trashed,omitempty"`
+	// The user's tags on the article, folders separated by slashes
+	Tags []string `json:"tags"`
+	// Only in the changes feed when contents are asked for. The contents are
+	// left out if the client has already been sent them.
+	ContentHash string `json:"contentHash,omitempty"`
+	Contents    string `json:"contents,omitempty"`
+}
+
+type ArticleList []ArticleEntry
+
+type Article struct {
+	Id       int64  `json:"id,omitempty"`
+	Title    string `json:"title"`
+	Url      string `json:"url"`
+	Contents string `json:"contents"`
+	Summary  string `json:"summary,omitempty"`
+	Status   string `json:"status"`
+	// Other urls the article was found under, such as redirects, which are
+	// recorded when it is stored
+	Aliases []string `json:"-"`
+}
+
+type Usage struct {
+	Url       string
+	Model     string
+	LengthIn  int
+	LengthOut int
+	TokensIn  int
+	TokensOut int
+}
+
+type Repo struct {
+	db *sql.DB
+	// Tells event streams about changes made through the repo
+	changes *broadcaster
+	// Decide which url an article is stored under
+	rules *CanonicalRules
+}
+
+// Opens the database, canonicalizing urls by the given rules or, if they
+// are nil, the default ones
+func NewRepo(dbfile string, rules *CanonicalRules) (Repo, error) {
+	db, err := sqlite.NewFromFile(dbfile, schema)
+	if err != nil {
+		return Repo{}, err
+	}
+	if rules == nil {
+		rules = DefaultCanonicalRules()
+	}
+
+	return Repo{db, newBroadcaster(), rules}, nil
+}
+
+func NewTestRepo() (Repo, error) {
+	db, err := sqlite.NewFromMemory(schema)
+	//db, err := sqlite.NewFromFile("/tmp/xxx.db", schema)
+	if err != nil {
+		return Repo{}, err
+	}
+
+	return Repo{db, newBroadcaster(), DefaultCanonicalRules()}, err
+}
+
+func (ctx *Repo) Close() {
+	ctx.db.Close()
+}
+
+// The database itself, for tests that set up or inspect tables directly
+func (repo *Repo) DB() *sql.DB {
+	return repo.db
+}
+
+// Returns the url an article at rawURL is stored under
+func (repo *Repo) CanonicalizeURL(rawURL string) (string, error) {
+	return repo.rules.Canonicalize(rawURL)
+}
+
+// The database or a transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Returns the url the article known as url is stored under: url itself,
+// the article it is an alias of, or the same for its canonical form or,
+// failing those, for the forms it had before the https and www rules. Urls
+// of articles that aren't stored are returned as they are.
+func (repo *Repo) resolve(ctx context.Context, q querier, url string) string {
+	canonical, err := repo.rules.Canonicalize(url)
+	if err != nil {
+		canonical = url
+	}
+	candidates := append([]string{url, canonical}, repo.rules.variants(canonical)...)
+	for _, candidate := range candidates {
+		row := q.QueryRowContext(ctx, `
+			SELECT COALESCE(
+			  (SELECT url FROM articles WHERE url = ?1),
+			  (SELECT url FROM aliases WHERE alias = ?1))`, candidate)
+		var resolved sql.NullString
+		if err := row.Scan(&resolved); err == nil && resolved.Valid {
+			return resolved.String
+		}
+	}
+	return url
+}
+
+// Record other urls the article at url is known by. Urls that other
+// articles are stored under are left alone.
+func addAliases(ctx context.Context, tx *sql.Tx, url string, aliases []string) error {
+	for _, alias := range aliases {
+		if alias == url {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO aliases (alias, url) SELECT ?1, ?2
+			WHERE NOT EXISTS (SELECT 1 FROM articles WHERE url = ?1)`,
+			alias, url)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record other urls a stored article is known by, such as a link that
+// redirected to it
+func (repo *Repo) AddAliases(ctx context.Context, url string, aliases []string) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := addAliases(ctx, tx, url, aliases); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns a channel that receives a value after the user's library changes,
+// and a function to call when done with it
+func (repo *Repo) Subscribe(user User) (<-chan struct{}, func()) {
+	return repo.changes.Subscribe(user)
+}
+
+// Assign articles saved before libraries were per-user to the given user
+func (repo *Repo) ClaimLegacyArticles(ctx context.Context, user User) error {
+	_, err := repo.db.ExecContext(ctx, "UPDATE OR IGNORE library SET user = ? WHERE user = ''", user)
+	if err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Returns a article contents if one exists in the user's library
+func (repo *Repo) Get(ctx context.Context, user User, url string) (*Article, bool) {
+	url = repo.resolve(ctx, repo.db, url)
+	row := repo.db.QueryRowContext(ctx, `
+		SELECT a.rowid, a.title, COALESCE(a.contents, ''), COALESCE(a.summary, ''), a.status FROM articles a INNER JOIN library l ON l.url = a.url
+		WHERE l.user = ? AND a.url = ? AND l.trashed IS NULL`, user, url)
+	art := Article{Url: url}
+	err := row.Scan(&art.Id, &art.Title, &art.Contents, &art.Summary, &art.Status)
+	if err != nil {
+		return &art, false
+	}
+	_, _ = repo.db.Exec(
+		"UPDATE library SET unread = false, unreadChanged = ?, unreadDevice = '', lastAccess = datetime('now') WHERE user = ? AND url = ? AND trashed IS NULL",
+		time.Now().UnixMilli(), user, url)
+	repo.changes.Notify(user)
+	return &art, true
+}
+
+// Returns an article in the user's library without marking it read
+func (repo *Repo) LibraryArticle(ctx context.Context, user User, url string) (*Article, bool) {
+	url = repo.resolve(ctx, repo.db, url)
+	row := repo.db.QueryRowContext(ctx, `
+		SELECT a.rowid, a.title, COALESCE(a.contents, ''), COALESCE(a.summary, ''), a.status FROM articles a INNER JOIN library l ON l.url = a.url
+		WHERE l.user = ? AND a.url = ? AND l.trashed IS NULL`, user, url)
+	art := Article{Url: url}
+	err := row.Scan(&art.Id, &art.Title, &art.Contents, &art.Summary, &art.Status)
+	if err != nil {
+		return &art, false
+	}
+	return &art, true
+}
+
+// Returns a article contents without updating unread status or lastAccess
+// time. Article contents are shared, so this finds articles in any library.
+func (repo *Repo) GetWithoutUpdating(ctx context.Context, url string) (*Article, bool) {
+	url = repo.resolve(ctx, repo.db, url)
+	row := repo.db.QueryRowContext(ctx, "SELECT rowid, title, COALESCE(contents, ''), COALESCE(summary, ''), status FROM articles WHERE url = ?", url)
+	art := Article{Url: url}
+	err := row.Scan(&art.Id, &art.Title, &art.Contents, &art.Summary, &art.Status)
+	if err != nil {
+		return &art, false
+	}
+	return &art, true
+}
+
+// Which articles in a user's library to list, and in what order. Articles
+// in the trash are never listed.
+type ListQuery struct {
+	// Only those with these flags, if set
+	Unread   *bool
+	Archived *bool
+	// Only those with the tag or any tag nested under it
+	Tag string
+	// Only those on the site or its subdomains
+	Domain string
+	// Only those added or last read in these ranges, from the first time
+	// and before the second. Zero times leave a range open.
+	CreatedSince, CreatedBefore       time.Time
+	LastAccessSince, LastAccessBefore time.Time
+	// One of ListSorts, and whether smallest first
+	Sort      string
+	Ascending bool
+	// Zero means no limit
+	Limit int
+	// Where the previous page ended, to list the page after it
+	After *ListPosition
+}
+
+// Where a page of a listing ended: the sort key and library entry of the
+// last article on it. Listing from a position rather than an offset means
+// articles added or changed meanwhile don't shift the pages.
+type ListPosition struct {
+	Key string
+	Id  int64
+}
+
+// The orders articles can be listed in, and the key each sorts on. Ties
+// are broken by the order the articles joined the library.
+var ListSorts = []string{"created", "lastAccess", "title", "length"}
+
+var listSortKeys = map[string]struct{ key, param string }{
+	"created":    {"l.created", "?"},
+	"lastAccess": {"l.lastAccess", "?"},
+	"title":      {"a.title COLLATE NOCASE", "?"},
+	"length":     {"length(COALESCE(a.contents, ''))", "CAST(? AS INTEGER)"},
+}
+
+// The host of an article's url
+const articleHost = `substr(substr(a.url, instr(a.url, '://') + 3), 1,
+		  instr(substr(a.url, instr(a.url, '://') + 3) || '/', '/') - 1)`
+
+// Returns a page of the articles in the user's library matching the query,
+// and the position to list the next page from, or nil if there is none
+func (repo *Repo) ListArticles(ctx context.Context, user User, q ListQuery) (ArticleList, *ListPosition, error) {
+	sort, ok := listSortKeys[q.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort: %s", q.Sort)
+	}
+	where := []string{"l.user = ?", "l.trashed IS NULL"}
+	args := []any{user}
+	if q.Unread != nil {
+		where = append(where, "l.unread = ?")
+		args = append(args, *q.Unread)
+	}
+	if q.Archived != nil {
+		where = append(where, "l.archived = ?")
+		args = append(args, *q.Archived)
+	}
+	if q.Tag != "" {
+		where = append(where, `l.url IN (
+		  SELECT x.url FROM articleTags x INNER JOIN tags t ON t.id = x.tag
+		  WHERE t.user = l.user AND (t.name = ? OR substr(t.name, 1, length(?) + 1) = ? || '/' COLLATE NOCASE))`)
+		args = append(args, q.Tag, q.Tag, q.Tag)
+	}
+	if q.Domain != "" {
+		where = append(where, "("+articleHost+" = ? OR substr("+articleHost+", -length(?) - 1) = '.' || ?)")
+		args = append(args, q.Domain, q.Domain, q.Domain)
+	}
+	for _, r := range []struct {
+		cond string
+		t    time.Time
+	}{
+		{"l.created >= ?", q.CreatedSince},
+		{"l.created < ?", q.CreatedBefore},
+		{"l.lastAccess >= ?", q.LastAccessSince},
+		{"l.lastAccess < ?", q.LastAccessBefore},
+	} {
+		if !r.t.IsZero() {
+			where = append(where, r.cond)
+			args = append(args, r.t.UTC().Format("2006-01-02 15:04:05"))
+		}
+	}
+	direction, compare := "DESC", "<"
+	if q.Ascending {
+		direction, compare = "ASC", ">"
+	}
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("(%s, l.rowid) %s (%s, ?)", sort.key, compare, sort.param))
+		args = append(args, q.After.Key, q.After.Id)
+	}
+	// One more than asked for tells whether there is another page
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit + 1
+	}
+	args = append(args, limit)
+
+	query := `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, l.lastAccess, ` + entryTags + `,
+		  CAST(` + sort.key + ` AS TEXT), l.rowid
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ` + sort.key + ` ` + direction + `, l.rowid ` + direction + ` LIMIT ?;`
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var result ArticleList
+	var positions []ListPosition
+
+	for rows.Next() {
+		var r ArticleEntry
+		var tags string
+		var pos ListPosition
+		err := rows.Scan(&r.Id, &r.Title, &r.Url, &r.HasBody, &r.Status, &r.Unread, &r.Archived, &r.LastAccess, &tags, &pos.Key, &pos.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.Tags = splitTags(tags)
+		result = append(result, r)
+		positions = append(positions, pos)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if q.Limit > 0 && len(result) > q.Limit {
+		return result[:q.Limit], &positions[q.Limit-1], nil
+	}
+	return result, nil, nil
+}
+
+// Returns the most recently-accessed articles that aren't archived
+func (repo *Repo) Recents(ctx context.Context, user User, count int) (ArticleList, error) {
+	archived := false
+	list, _, err := repo.ListArticles(ctx, user, ListQuery{Archived: &archived, Sort: "lastAccess", Limit: count})
+	return list, err
+}
+
+// Returns the most recently added articles that are archived
+func (repo *Repo) Archive(ctx context.Context, user User, count int) (ArticleList, error) {
+	archived := true
+	list, _, err := repo.ListArticles(ctx, user, ListQuery{Archived: &archived, Sort: "created", Limit: count})
+	return list, err
+}
+
+// Insert the article contents corresponding to the url into the database
+// and add the article to the user's library
+func (repo *Repo) Insert(ctx context.Context, user User, art *Article) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	art.Url = repo.resolve(ctx, tx, art.Url)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO articles (title, url, contents, summary) VALUES (?, ?, ?, NULLIF(?, '')) ON CONFLICT DO NOTHING",
+		art.Title, art.Url, art.Contents, art.Summary)
+	if err != nil {
+		return err
+	}
+	// The id of the stored article, which may have been there already
+	if err := tx.QueryRowContext(ctx, "SELECT rowid FROM articles WHERE url = ?", art.Url).Scan(&art.Id); err != nil {
+		return err
+	}
+	if err := addAliases(ctx, tx, art.Url, art.Aliases); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, addToLibrary, user, art.Url)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Insert the article contents with a custom created timestamp
+func (repo *Repo) InsertWithTimestamp(ctx context.Context, user User, art *Article, createdTime string) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	art.Url = repo.resolve(ctx, tx, art.Url)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO articles (title, url, contents, summary, created) VALUES (?, ?, ?, NULLIF(?, ''), ?) ON CONFLICT DO NOTHING",
+		art.Title, art.Url, art.Contents, art.Summary, createdTime)
+	if err != nil {
+		return err
+	}
+	// The id of the stored article, which may have been there already
+	if err := tx.QueryRowContext(ctx, "SELECT rowid FROM articles WHERE url = ?", art.Url).Scan(&art.Id); err != nil {
+		return err
+	}
+	if err := addAliases(ctx, tx, art.Url, art.Aliases); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO library (user, url, created) VALUES (?, ?, ?)",
+		user, art.Url, createdTime)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Add an article that couldn't be fetched to the user's library with just
+// its title, so that it isn't lost; it can be fetched again later
+func (repo *Repo) InsertPlaceholder(ctx context.Context, user User, art *Article, createdTime string, fetchError string) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	art.Url = repo.resolve(ctx, tx, art.Url)
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO articles (title, url, status, fetchError, created) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		art.Title, art.Url, StatusFailed, fetchError, createdTime)
+	if err != nil {
+		return err
+	}
+	// The id of the stored article, which may have been there already
+	if err := tx.QueryRowContext(ctx, "SELECT rowid FROM articles WHERE url = ?", art.Url).Scan(&art.Id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO library (user, url, created) VALUES (?, ?, ?)",
+		user, art.Url, createdTime)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Returns the stored articles that have been fetched or have failed to be,
+// or only those that failed, oldest first. Zero means no limit.
+func (repo *Repo) StoredArticles(ctx context.Context, onlyFailed bool, limit int) ([]Article, error) {
+	// SQLite takes a negative limit as none
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := repo.db.QueryContext(ctx, `
+		SELECT rowid, title, url, COALESCE(contents, ''), COALESCE(summary, ''), status FROM articles
+		WHERE status = ?1 OR (NOT ?2 AND status = ?3)
+		ORDER BY created LIMIT ?4`,
+		StatusFailed, onlyFailed, StatusReady, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []Article
+
+	for rows.Next() {
+		var art Article
+		if err := rows.Scan(&art.Id, &art.Title, &art.Url, &art.Contents, &art.Summary, &art.Status); err != nil {
+			return nil, err
+		}
+		result = append(result, art)
+	}
+	return result, rows.Err()
+}
+
+// Returns the status of the article stored under exactly this url, whether
+// or not it has been fetched yet.
+func (repo *Repo) ArticleStatus(ctx context.Context, url string) (string, bool) {
+	var status string
+	err := repo.db.QueryRowContext(ctx, "SELECT status FROM articles WHERE url = ?", url).Scan(&status)
+	if err != nil {
+		return "", false
+	}
+	return status, true
+}
+
+// Replace the contents of a stored article with those fetched again. A
+// TL;DR is kept unless there is a new one.
+func (repo *Repo) UpdateContents(ctx context.Context, art *Article) error {
+	_, err := repo.db.ExecContext(ctx, `
+		UPDATE articles SET title = ?, contents = ?, summary = COALESCE(NULLIF(?, ''), summary),
+		  status = ?, fetchError = NULL
+		WHERE url = ?`,
+		art.Title, art.Contents, art.Summary, StatusReady, art.Url)
+	if err != nil {
+		return err
+	}
+	repo.changes.NotifyAll()
+	return nil
+}
+
+// Record why an article couldn't be fetched
+func (repo *Repo) SetFetchError(ctx context.Context, url string, message string) error {
+	_, err := repo.db.ExecContext(ctx, "UPDATE articles SET fetchError = ? WHERE url = ?", message, url)
+	return err
+}
+
+// Adds an article to a user's library, taking it out of the trash if it
+// was there, since saving it again means the user wants it after all
+const addToLibrary = `
+	INSERT INTO library (user, url) VALUES (?, ?)
+	ON CONFLICT DO UPDATE SET trashed = NULL WHERE trashed IS NOT NULL`
+
+// Add an article whose contents are already stored to the user's library
+func (repo *Repo) AddToLibrary(ctx context.Context, user User, url string) error {
+	_, err := repo.db.ExecContext(ctx, addToLibrary, user, repo.resolve(ctx, repo.db, url))
+	if err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Set the archive status of an article in the user's library
+func (repo *Repo) SetArchive(ctx context.Context, user User, url string, archive bool) error {
+	_, err := repo.db.ExecContext(ctx,
+		"UPDATE library SET archived = ?, archivedChanged = ?, archivedDevice = '' WHERE user = ? AND url = ? AND trashed IS NULL",
+		archive, time.Now().UnixMilli(), user, repo.resolve(ctx, repo.db, url))
+	if err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Mark an article as read by updating unread status and lastAccess time
+func (repo *Repo) MarkRead(ctx context.Context, user User, url string) error {
+	_, err := repo.db.ExecContext(ctx,
+		"UPDATE library SET unread = false, unreadChanged = ?, unreadDevice = '', lastAccess = datetime('now') WHERE user = ? AND url = ? AND trashed IS NULL",
+		time.Now().UnixMilli(), user, repo.resolve(ctx, repo.db, url))
+	if err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+// Returns the url of the article with the id if it is in the user's
+// library. Unlike its url, an article's id doesn't change when it is
+// canonicalized or merged into.
+func (repo *Repo) ArticleURL(ctx context.Context, user User, id int64) (string, bool) {
+	row := repo.db.QueryRowContext(ctx, `
+		SELECT a.url FROM articles a INNER JOIN library l ON l.url = a.url
+		WHERE l.user = ? AND a.rowid = ?`, user, id)
+	var url string
+	if err := row.Scan(&url); err != nil {
+		return "", false
+	}
+	return url, true
+}
+
+// Put an article in the user's library in the trash, from which it can be
+// restored until it is purged. Returns false if it isn't in their library
+// or is already in the trash.
+func (repo *Repo) Trash(ctx context.Context, user User, url string) (bool, error) {
+	result, err := repo.db.ExecContext(ctx,
+		"UPDATE library SET trashed = datetime('now') WHERE user = ? AND url = ? AND trashed IS NULL",
+		user, repo.resolve(ctx, repo.db, url))
+	if err != nil {
+		return false, err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return false, nil
+	}
+	repo.changes.Notify(user)
+	return true, nil
+}
+
+// Take an article out of the trash. Returns false if it isn't in the
+// user's trash.
+func (repo *Repo) Restore(ctx context.Context, user User, url string) (bool, error) {
+	result, err := repo.db.ExecContext(ctx,
+		"UPDATE library SET trashed = NULL WHERE user = ? AND url = ? AND trashed IS NOT NULL",
+		user, repo.resolve(ctx, repo.db, url))
+	if err != nil {
+		return false, err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return false, nil
+	}
+	repo.changes.Notify(user)
+	return true, nil
+}
+
+// Returns the articles in the user's trash, most recently trashed first
+func (repo *Repo) Trashed(ctx context.Context, user User, count int) (ArticleList, error) {
+	query := `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, l.lastAccess, l.trashed, ` + entryTags + `
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		WHERE l.user = ? AND l.trashed IS NOT NULL
+		ORDER BY l.trashed DESC LIMIT ?;`
+	rows, err := repo.db.QueryContext(ctx, query, user, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result ArticleList
+
+	for rows.Next() {
+		var r ArticleEntry
+		var tags string
+		err := rows.Scan(&r.Id, &r.Title, &r.Url, &r.HasBody, &r.Status, &r.Unread, &r.Archived, &r.LastAccess, &r.Trashed, &tags)
+		if err != nil {
+			return nil, err
+		}
+		r.Tags = splitTags(tags)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Remove the articles that have been in the trash for longer than the
+// retention period from their libraries for good, leaving tombstones for
+// clients syncing changes. Returns how many were removed.
+func (repo *Repo) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	result, err := repo.db.ExecContext(ctx,
+		"DELETE FROM library WHERE trashed < datetime('now', ?)",
+		fmt.Sprintf("-%d seconds", int(retention.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+	count, _ := result.RowsAffected()
+	if count > 0 {
+		repo.changes.NotifyAll()
+	}
+	return count, nil
+}
+
+// A change to a field of a library entry made by a client
+type Mutation struct {
+	Url       string `json:"url"`
+	Field     string `json:"field"` // unread or archived
+	Value     bool   `json:"value"`
+	Timestamp int64  `json:"timestamp"` // milliseconds since the epoch
+}
+
+type MutationResult struct {
+	Url     string `json:"url"`
+	Field   string `json:"field"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Statements applying a mutation to each field. A mutation only applies if
+// it is newer than the last change to the field; ties go to the greater
+// device id so that every server and client agrees on the winner.
+var mutationStatements = map[string]string{
+	"unread": `
+		UPDATE library SET unread = ?1, unreadChanged = ?2, unreadDevice = ?3,
+		  lastAccess = CASE WHEN ?1 THEN lastAccess ELSE datetime(?2 / 1000, 'unixepoch') END
+		WHERE user = ?4 AND url = ?5 AND trashed IS NULL AND (unreadChanged < ?2 OR (unreadChanged = ?2 AND unreadDevice < ?3))`,
+	"archived": `
+		UPDATE library SET archived = ?1, archivedChanged = ?2, archivedDevice = ?3
+		WHERE user = ?4 AND url = ?5 AND trashed IS NULL AND (archivedChanged < ?2 OR (archivedChanged = ?2 AND archivedDevice < ?3))`,
+}
+
+// Apply a batch of changes made on a device, last writer wins per field.
+// Returns whether each mutation was applied; mutations that lost to a later
+// change aren't errors.
+func (repo *Repo) ApplyMutations(ctx context.Context, user User, device string, mutations []Mutation) ([]MutationResult, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	results := make([]MutationResult, len(mutations))
+	for i, m := range mutations {
+		results[i] = MutationResult{Url: m.Url, Field: m.Field}
+		stmt, ok := mutationStatements[m.Field]
+		if !ok {
+			results[i].Error = fmt.Sprintf("unknown field: %s", m.Field)
+			continue
+		}
+		url := repo.resolve(ctx, tx, m.Url)
+		result, err := tx.ExecContext(ctx, stmt, m.Value, m.Timestamp, device, user, url)
+		if err != nil {
+			return nil, err
+		}
+		if count, _ := result.RowsAffected(); count > 0 {
+			results[i].Applied = true
+			continue
+		}
+		var exists bool
+		row := tx.QueryRowContext(ctx, "SELECT count(*) > 0 FROM library WHERE user = ? AND url = ? AND trashed IS NULL", user, url)
+		if err := row.Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			results[i].Error = "not in library"
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	repo.changes.Notify(user)
+	return results, nil
+}
+
+// Search for articles matching a pattern
+func (repo *Repo) Search(ctx context.Context, user User, pattern string) (ArticleList, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	// If the final token in the pattern is a letter, add a star to treat it as
+	// a prefix query
+	lastRune, _ := utf8.DecodeLastRuneInString(pattern)
+	if unicode.IsLetter(lastRune) {
+		pattern += "*"
+	}
+	// Tags are indexed separately, so an article matches if its title and
+	// contents match or its tags do
+	rows, err := repo.db.QueryContext(ctx, `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, `+entryTags+`
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		  LEFT JOIN (SELECT url, rank FROM fts WHERE fts MATCH ?2) f ON f.url = a.url
+		  LEFT JOIN (SELECT url, rank FROM tagFts WHERE tagFts MATCH ?2 AND user = ?1) t ON t.url = a.url
+		WHERE l.user = ?1 AND l.trashed IS NULL AND (f.url IS NOT NULL OR t.url IS NOT NULL)
+		ORDER BY min(COALESCE(f.rank, 0), COALESCE(t.rank, 0))`, user, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result ArticleList
+
+	for rows.Next() {
+		var r ArticleEntry
+		var tags string
+		err := rows.Scan(&r.Id, &r.Title, &r.Url, &r.HasBody, &r.Status, &r.Unread, &r.Archived, &tags)
+		if err != nil {
+			return nil, err
+		}
+		r.Tags = splitTags(tags)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// The names of the tags on a library entry l, separated by newlines
+const entryTags = `COALESCE((SELECT group_concat(t.name, char(10)) FROM articleTags x INNER JOIN tags t ON t.id = x.tag
+		  WHERE x.url = l.url AND t.user = l.user), '')`
+
+func splitTags(tags string) []string {
+	if tags == "" {
+		return []string{}
+	}
+	result := strings.Split(tags, "\n")
+	slices.SortFunc(result, func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	return result
+}
+
+// Tag an article in the user's library. Returns false if the article isn't
+// in their library.
+func (repo *Repo) AddTags(ctx context.Context, user User, url string, tags []string) (bool, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	url = repo.resolve(ctx, tx, url)
+	var exists bool
+	row := tx.QueryRowContext(ctx, "SELECT count(*) > 0 FROM library WHERE user = ? AND url = ?", user, url)
+	if err := row.Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, "INSERT INTO tags (user, name) VALUES (?, ?) ON CONFLICT DO NOTHING", user, tag)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO articleTags (tag, url) SELECT id, ? FROM tags WHERE user = ? AND name = ?",
+			url, user, tag)
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	repo.changes.Notify(user)
+	return true, nil
+}
+
+// Remove tags from an article in the user's library. Tags left on no
+// articles are forgotten.
+func (repo *Repo) RemoveTags(ctx context.Context, user User, url string, tags []string) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	url = repo.resolve(ctx, tx, url)
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM articleTags WHERE url = ? AND tag = (SELECT id FROM tags WHERE user = ? AND name = ?)",
+			url, user, tag)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM tags WHERE user = ? AND NOT EXISTS (SELECT 1 FROM articleTags x WHERE x.tag = tags.id)",
+		user)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	repo.changes.Notify(user)
+	return nil
+}
+
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Returns the user's tags and how many articles have each, not counting
+// those in the trash
+func (repo *Repo) Tags(ctx context.Context, user User) ([]TagCount, error) {
+	rows, err := repo.db.QueryContext(ctx, `
+		SELECT t.name, count(l.url) FROM tags t LEFT JOIN articleTags x ON x.tag = t.id
+		  LEFT JOIN library l ON l.url = x.url AND l.user = t.user AND l.trashed IS NULL
+		WHERE t.user = ? GROUP BY t.id ORDER BY t.name`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []TagCount{}
+
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// Returns the most recently added articles with the tag or any tag nested
+// under it
+func (repo *Repo) Tagged(ctx context.Context, user User, tag string, count int) (ArticleList, error) {
+	query := `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, l.lastAccess, ` + entryTags + `
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		WHERE l.user = ?1 AND l.trashed IS NULL AND l.url IN (
+		  SELECT x.url FROM articleTags x INNER JOIN tags t ON t.id = x.tag
+		  WHERE t.user = ?1 AND (t.name = ?2 OR substr(t.name, 1, length(?2) + 1) = ?2 || '/' COLLATE NOCASE))
+		ORDER BY l.created DESC LIMIT ?3;`
+	rows, err := repo.db.QueryContext(ctx, query, user, tag, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result ArticleList
+
+	for rows.Next() {
+		var r ArticleEntry
+		var tags string
+		err := rows.Scan(&r.Id, &r.Title, &r.Url, &r.HasBody, &r.Status, &r.Unread, &r.Archived, &r.LastAccess, &tags)
+		if err != nil {
+			return nil, err
+		}
+		r.Tags = splitTags(tags)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Everything about an article in a user's library
+type ExportedArticle struct {
+	Id         int64     `json:"id"`
+	Url        string    `json:"url"`
+	Title      string    `json:"title"`
+	Contents   string    `json:"contents,omitempty"`
+	Summary    string    `json:"summary,omitempty"`
+	Status     string    `json:"status"`
+	FetchError string    `json:"fetchError,omitempty"`
+	Unread     bool      `json:"unread"`
+	Archived   bool      `json:"archived"`
+	Tags       []string  `json:"tags"`
+	Added      time.Time `json:"added"`
+	LastAccess time.Time `json:"lastAccess"`
+	// When the article was put in the trash, if it is there
+	Trashed *time.Time `json:"trashed,omitempty"`
+	// Other urls the article is known by
+	Aliases []string `json:"aliases"`
+	// When unread and archived last changed, in milliseconds since the
+	// epoch, and on which device, for settling sync conflicts
+	UnreadChanged   int64  `json:"unreadChanged"`
+	UnreadDevice    string `json:"unreadDevice"`
+	ArchivedChanged int64  `json:"archivedChanged"`
+	ArchivedDevice  string `json:"archivedDevice"`
+}
+
+// How many articles ExportArticles reads at a time
+const ExportPageSize = 100
+
+// Calls fn with each article in the user's library in the order they were
+// added, including those in the trash. Articles are read a page at a time, so that the database isn't
+// held up while fn writes them somewhere slow.
+func (repo *Repo) ExportArticles(ctx context.Context, user User, fn func(ExportedArticle) error) error {
+	after := int64(0)
+	for {
+		rows, err := repo.db.QueryContext(ctx, `
+			SELECT l.rowid, a.rowid, a.url, a.title, COALESCE(a.contents, ''), COALESCE(a.summary, ''), a.status,
+			  COALESCE(a.fetchError, ''), l.unread, l.archived, l.created, l.lastAccess, l.trashed, `+entryTags+`,
+			  COALESCE((SELECT group_concat(alias, char(10)) FROM aliases WHERE url = a.url), ''),
+			  l.unreadChanged, l.unreadDevice, l.archivedChanged, l.archivedDevice
+			FROM library l INNER JOIN articles a ON a.url = l.url
+			WHERE l.user = ? AND l.rowid > ?
+			ORDER BY l.rowid LIMIT ?`, user, after, ExportPageSize)
+		if err != nil {
+			return err
+		}
+		var page []ExportedArticle
+		for rows.Next() {
+			var art ExportedArticle
+			var trashed sql.NullTime
+			var tags, aliases string
+			err := rows.Scan(&after, &art.Id, &art.Url, &art.Title, &art.Contents, &art.Summary, &art.Status,
+				&art.FetchError, &art.Unread, &art.Archived, &art.Added, &art.LastAccess, &trashed, &tags,
+				&aliases, &art.UnreadChanged, &art.UnreadDevice, &art.ArchivedChanged, &art.ArchivedDevice)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if trashed.Valid {
+				art.Trashed = &trashed.Time
+			}
+			art.Tags = splitTags(tags)
+			art.Aliases = []string{}
+			if aliases != "" {
+				art.Aliases = strings.Split(aliases, "\n")
+				slices.Sort(art.Aliases)
+			}
+			page = append(page, art)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		for _, art := range page {
+			if err := fn(art); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Record the resources used by a call to the LLM
+func (repo *Repo) Usage(ctx context.Context, usage Usage) error {
+	_, err := repo.db.ExecContext(ctx,
+		"INSERT INTO usage (url, model, lengthIn, lengthOut, tokensIn, tokensOut) VALUES (?, ?, ?, ?, ?, ?)",
+		usage.Url, usage.Model, usage.LengthIn, usage.LengthOut, usage.TokensIn, usage.TokensOut)
+	return err
+}
+
+// Usage totalled by period, url and model
+type UsageRow struct {
+	Period    string
+	Url       string
+	Model     string
+	Calls     int
+	TokensIn  int
+	TokensOut int
+	LengthIn  int
+	LengthOut int
+}
+
+// How usage timestamps are grouped into periods. Weeks start on Monday.
+var usagePeriodExprs = map[string]string{
+	"day":   "date(timestamp)",
+	"week":  "date(timestamp, 'weekday 0', '-6 days')",
+	"month": "strftime('%Y-%m', timestamp)",
+}
+
+// Returns the usage since the given date, or all of it if since is empty,
+// totalled by day, week or month
+func (repo *Repo) UsageRows(ctx context.Context, period string, since string) ([]UsageRow, error) {
+	expr, ok := usagePeriodExprs[period]
+	if !ok {
+		return nil, fmt.Errorf("invalid period: %s", period)
+	}
+	query := fmt.Sprintf(`
+		SELECT %s, COALESCE(url, ''), COALESCE(model, ''), count(*),
+		  COALESCE(sum(tokensIn), 0), COALESCE(sum(tokensOut), 0),
+		  COALESCE(sum(lengthIn), 0), COALESCE(sum(lengthOut), 0)
+		FROM usage WHERE ? = '' OR timestamp >= ?
+		GROUP BY 1, 2, 3`, expr)
+	rows, err := repo.db.QueryContext(ctx, query, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []UsageRow
+
+	for rows.Next() {
+		var r UsageRow
+		err := rows.Scan(&r.Period, &r.Url, &r.Model, &r.Calls, &r.TokensIn, &r.TokensOut, &r.LengthIn, &r.LengthOut)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Returns the number of tokens used since the given time
+func (repo *Repo) TokensSince(ctx context.Context, since time.Time) (int, error) {
+	row := repo.db.QueryRowContext(ctx,
+		"SELECT COALESCE(sum(tokensIn + tokensOut), 0) FROM usage WHERE timestamp >= ?",
+		since.UTC().Format("2006-01-02 15:04:05"))
+	var tokens int
+	err := row.Scan(&tokens)
+	return tokens, err
+}
+
+// Convert ISO format timestamp to SQLite format if needed
+// ISO: "2024-01-01T12:00:00.000Z" -> SQLite: "2024-01-01 12:00:00"
+func sqliteTime(since string) string {
+	if strings.Contains(since, "T") {
+		// Parse ISO format and convert to SQLite format
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			return t.UTC().Format("2006-01-02 15:04:05")
+		}
+	}
+	return since
+}
+
+// Get articles that have been modified since the given timestamp
+func (repo *Repo) GetChangesSince(ctx context.Context, user User, since string) (ArticleList, error) {
+	sqliteSince := sqliteTime(since)
+	if err := repo.CheckChangesSince(ctx, user, since); err != nil {
+		return nil, err
+	}
+
+	// An article changes for a user when their library entry changes or when
+	// its shared contents do. Articles that haven't been updated since they
+	// were inserted have no lastModified. Articles removed from the library
+	// or put in the trash are reported as deleted.
+	query := `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, l.lastAccess,
+		  l.trashed IS NOT NULL, max(l.lastModified, COALESCE(a.lastModified, a.created)), ` + entryTags + `
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		WHERE l.user = ? AND max(l.lastModified, COALESCE(a.lastModified, a.created)) > ?
+		UNION ALL
+		SELECT 0, '', url, false, '', false, false, deleted, true, deleted, ''
+		FROM tombstones
+		WHERE user = ? AND deleted > ?
+		ORDER BY 10 DESC`
+
+	rows, err := repo.db.QueryContext(ctx, query, user, sqliteSince, user, sqliteSince)
+	if err != nil {
+		return nil, err
+	}
+	return scanChanges(rows)
+}
+
+func scanChanges(rows *sql.Rows) (ArticleList, error) {
+	defer rows.Close()
+	var result ArticleList
+
+	for rows.Next() {
+		var r ArticleEntry
+		var modified any
+		var tags string
+		err := rows.Scan(&r.Id, &r.Title, &r.Url, &r.HasBody, &r.Status, &r.Unread, &r.Archived, &r.LastAccess, &r.Deleted, &modified, &tags)
+		if err != nil {
+			return nil, err
+		}
+		r.Tags = splitTags(tags)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Returns the current position in the change sequence
+func (repo *Repo) ChangeSequence(ctx context.Context) (int64, error) {
+	var seq int64
+	err := repo.db.QueryRowContext(ctx, "SELECT value FROM changeSequence WHERE id = 0").Scan(&seq)
+	return seq, err
+}
+
+// Get the changes to the user's library after the given position in the
+// change sequence, along with the position they run up to
+func (repo *Repo) GetChangesAfter(ctx context.Context, user User, after int64) (ArticleList, int64, error) {
+	if err := repo.CheckChangesAfter(ctx, user, after); err != nil {
+		return nil, 0, err
+	}
+	// Changes committed after we read the position are left for next time
+	upto, err := repo.ChangeSequence(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, l.lastAccess,
+		  l.trashed IS NOT NULL, max(l.seq, a.seq), ` + entryTags + `
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		WHERE l.user = ?1 AND max(l.seq, a.seq) > ?2 AND max(l.seq, a.seq) <= ?3
+		UNION ALL
+		SELECT 0, '', url, false, '', false, false, deleted, true, seq, ''
+		FROM tombstones
+		WHERE user = ?1 AND seq > ?2 AND seq <= ?3
+		ORDER BY 10`
+	rows, err := repo.db.QueryContext(ctx, query, user, after, upto)
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := scanChanges(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, upto, nil
+}
+
+// Get the changes to the user's library after the given position in the
+// change sequence with the contents of the articles that changed or joined
+// the library after the base position, which is where the client last
+// caught up. Stops early once the changes reach maxBytes, returning the
+// position to continue from and more set; otherwise the position the
+// changes run up to.
+func (repo *Repo) GetContentChangesAfter(ctx context.Context, user User, after int64, base int64, maxBytes int) (ArticleList, int64, bool, error) {
+	if err := repo.CheckChangesAfter(ctx, user, after); err != nil {
+		return nil, 0, false, err
+	}
+	upto, err := repo.ChangeSequence(ctx)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	query := `
+		SELECT a.rowid, a.title, a.url, (a.contents IS NOT NULL), a.status, l.unread, l.archived, l.lastAccess,
+		  l.trashed IS NOT NULL, max(l.seq, a.seq), COALESCE(a.contents, ''), (a.contentSeq > ?4 OR l.addedSeq > ?4), ` + entryTags + `
+		FROM library l INNER JOIN articles a ON a.url = l.url
+		WHERE l.user = ?1 AND max(l.seq, a.seq) > ?2 AND max(l.seq, a.seq) <= ?3
+		UNION ALL
+		SELECT 0, '', url, false, '', false, false, deleted, true, seq, '', false, ''
+		FROM tombstones
+		WHERE user = ?1 AND seq > ?2 AND seq <= ?3
+		ORDER BY 10`
+	rows, err := repo.db.QueryContext(ctx, query, user, after, upto, base)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer rows.Close()
+	var result ArticleList
+	size := 0
+
+	for rows.Next() {
+		var r ArticleEntry
+		var seq int64
+		var contents string
+		var unseen bool
+		var tags string
+		err := rows.Scan(&r.Id, &r.Title, &r.Url, &r.HasBody, &r.Status, &r.Unread, &r.Archived, &r.LastAccess, &r.Deleted, &seq, &contents, &unseen, &tags)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		r.Tags = splitTags(tags)
+		if r.HasBody && !r.Deleted {
+			sum := sha256.Sum256([]byte(contents))
+			r.ContentHash = hex.EncodeToString(sum[:])
+			if unseen {
+				r.Contents = contents
+			}
+		}
+		// Roughly the size of the entry once encoded
+		entrySize := len(r.Title) + len(r.Url) + len(r.Contents) + 200
+		if len(result) > 0 && size+entrySize > maxBytes {
+			return result, after, true, nil
+		}
+		result = append(result, r)
+		size += entrySize
+		after = seq
+	}
+	return result, upto, false, rows.Err()
+}
+
+// Forget deletions older than the horizon, remembering how far they went
+// so that clients that haven't synced since are told to sync afresh
+func (repo *Repo) PruneTombstones(ctx context.Context, horizon time.Duration) error {
+	cutoff := time.Now().Add(-horizon).UTC().Format("2006-01-02 15:04:05")
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO tombstonesPruned (user, seq, deleted)
+		SELECT user, max(seq), max(deleted) FROM tombstones WHERE deleted < ? GROUP BY user
+		ON CONFLICT (user) DO UPDATE SET seq = max(seq, excluded.seq), deleted = max(deleted, excluded.deleted)`,
+		cutoff)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM tombstones WHERE deleted < ?", cutoff)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ErrChangesPruned is returned for changes since a time or position from
+// before deletions were last forgotten, since those deletions can no longer
+// be reported. The client has to sync afresh.
+var ErrChangesPruned = errors.New("deletions since then have been forgotten, sync afresh")
+
+// Returns ErrChangesPruned if deletions from the user's library after the
+// given position in the change sequence have been forgotten. Nothing is
+// missed from the start of the sequence, which is a full sync.
+func (repo *Repo) CheckChangesAfter(ctx context.Context, user User, after int64) error {
+	if after == 0 {
+		return nil
+	}
+	var pruned int64
+	err := repo.db.QueryRowContext(ctx,
+		"SELECT COALESCE(max(seq), 0) FROM tombstonesPruned WHERE user = ?", user).Scan(&pruned)
+	if err != nil {
+		return err
+	}
+	if pruned > after {
+		return ErrChangesPruned
+	}
+	return nil
+}
+
+// CheckChangesAfter for a time. Nothing is missed since the empty time.
+func (repo *Repo) CheckChangesSince(ctx context.Context, user User, since string) error {
+	if since == "" {
+		return nil
+	}
+	since = sqliteTime(since)
+	var pruned string
+	err := repo.db.QueryRowContext(ctx,
+		"SELECT COALESCE(max(deleted), '') FROM tombstonesPruned WHERE user = ?", user).Scan(&pruned)
+	if err != nil {
+		return err
+	}
+	if pruned > since {
+		return ErrChangesPruned
+	}
+	return nil
+}
+
+// Tokens are stored hashed so that a leaked database doesn't leak sessions
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Create a new session for the user and return its token
+func (repo *Repo) CreateSession(ctx context.Context, user User, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	expires := time.Now().Add(ttl).UTC().Format("2006-01-02 15:04:05")
+	_, err = repo.db.ExecContext(ctx,
+		"INSERT INTO sessions (token, user, expires) VALUES (?, ?, ?)",
+		hashToken(token), user, expires)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Returns the user owning an unexpired session
+func (repo *Repo) SessionUser(ctx context.Context, token string) (User, bool) {
+	row := repo.db.QueryRowContext(ctx,
+		"SELECT user FROM sessions WHERE token = ? AND expires > datetime('now')",
+		hashToken(token))
+	var user User
+	err := row.Scan(&user)
+	if err != nil {
+		return "", false
+	}
+	return user, true
+}
+
+func (repo *Repo) DeleteSession(ctx context.Context, token string) error {
+	_, err := repo.db.ExecContext(ctx, "DELETE FROM sessions WHERE token = ?", hashToken(token))
+	return err
+}
+
+// Remove sessions that have expired
+func (repo *Repo) PruneSessions(ctx context.Context) error {
+	_, err := repo.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires <= datetime('now')")
+	return err
+}
+
+type ApiToken struct {
+	Id       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Created  string   `json:"created"`
+	LastUsed *string  `json:"lastUsed"`
+}
+
+// Create a long-lived API token for the user and return the token itself,
+// which is not stored and can't be retrieved later
+func (repo *Repo) CreateToken(ctx context.Context, user User, name string, scopes []string) (int64, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return 0, "", err
+	}
+	result, err := repo.db.ExecContext(ctx,
+		"INSERT INTO apiTokens (user, name, token, scopes) VALUES (?, ?, ?, ?)",
+		user, name, hashToken(token), strings.Join(scopes, " "))
+	if err != nil {
+		return 0, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+	return id, token, nil
+}
+
+// Returns the user and scopes of an API token, recording its use
+func (repo *Repo) TokenUser(ctx context.Context, token string) (User, []string, bool) {
+	hash := hashToken(token)
+	row := repo.db.QueryRowContext(ctx, "SELECT user, scopes FROM apiTokens WHERE token = ?", hash)
+	var user User
+	var scopes string
+	err := row.Scan(&user, &scopes)
+	if err != nil {
+		return "", nil, false
+	}
+	_, _ = repo.db.ExecContext(ctx, "UPDATE apiTokens SET lastUsed = datetime('now') WHERE token = ?", hash)
+	return user, strings.Fields(scopes), true
+}
+
+// Returns the user's API tokens
+func (repo *Repo) Tokens(ctx context.Context, user User) ([]ApiToken, error) {
+	rows, err := repo.db.QueryContext(ctx,
+		"SELECT id, name, scopes, created, lastUsed FROM apiTokens WHERE user = ? ORDER BY id",
+		user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []ApiToken{}
+
+	for rows.Next() {
+		var t ApiToken
+		var scopes string
+		err := rows.Scan(&t.Id, &t.Name, &scopes, &t.Created, &t.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// Revoke one of the user's API tokens. Returns false if there was no such
+// token.
+func (repo *Repo) DeleteToken(ctx context.Context, user User, id int64) (bool, error) {
+	result, err := repo.db.ExecContext(ctx, "DELETE FROM apiTokens WHERE user = ? AND id = ?", user, id)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// Add a pending article to the user's library and schedule it to be
+// fetched. Returns the stored article, which may already be fetched if
+// another user got there first.
+func (repo *Repo) Enqueue(ctx context.Context, user User, url string, titleHint string) (*Article, error) {
+	title := titleHint
+	if title == "" {
+		title = url
+	}
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	url = repo.resolve(ctx, tx, url)
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO articles (url, title, status) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		url, title, StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO jobs (url, user, titleHint) VALUES (?, ?, ?)",
+			url, user, titleHint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, addToLibrary, user, url)
+	if err != nil {
+		return nil, err
+	}
+	art := Article{Url: url}
+	row := tx.QueryRowContext(ctx, "SELECT rowid, title, COALESCE(contents, ''), COALESCE(summary, ''), status FROM articles WHERE url = ?", url)
+	if err := row.Scan(&art.Id, &art.Title, &art.Contents, &art.Summary, &art.Status); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	repo.changes.Notify(user)
+	return &art, nil
+}
+
+// Schedule an article that failed to fetch to be fetched again
+func (repo *Repo) Requeue(ctx context.Context, user User, url string, titleHint string) (*Article, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	url = repo.resolve(ctx, tx, url)
+	result, err := tx.ExecContext(ctx,
+		"UPDATE articles SET status = ? WHERE url = ? AND status = ?",
+		StatusPending, url, StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO jobs (url, user, titleHint) VALUES (?, ?, ?)",
+			url, user, titleHint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, addToLibrary, user, url)
+	if err != nil {
+		return nil, err
+	}
+	art := Article{Url: url}
+	row := tx.QueryRowContext(ctx, "SELECT rowid, title, COALESCE(contents, ''), COALESCE(summary, ''), status FROM articles WHERE url = ?", url)
+	if err := row.Scan(&art.Id, &art.Title, &art.Contents, &art.Summary, &art.Status); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	repo.changes.Notify(user)
+	return &art, nil
+}
+
+// Make jobs that were running when the server stopped available again
+func (repo *Repo) ResetJobs(ctx context.Context) error {
+	_, err := repo.db.ExecContext(ctx, "UPDATE jobs SET running = false WHERE running")
+	return err
+}
+
+// Claim the next job that is due. Returns false if there is none.
+func (repo *Repo) ClaimJob(ctx context.Context) (Job, bool, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Job{}, false, err
+	}
+	defer tx.Rollback()
+	row := tx.QueryRowContext(ctx, `
+		UPDATE jobs SET running = true
+		WHERE id = (SELECT id FROM jobs WHERE NOT running AND nextAttempt <= datetime('now')
+		            ORDER BY nextAttempt LIMIT 1)
+		RETURNING id, url, user, COALESCE(titleHint, ''), attempts`)
+	var j Job
+	err = row.Scan(&j.Id, &j.Url, &j.User, &j.TitleHint, &j.Attempts)
+	if err == sql.ErrNoRows {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE articles SET status = ? WHERE url = ?", StatusFetching, j.Url)
+	if err != nil {
+		return Job{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Job{}, false, err
+	}
+	repo.changes.NotifyAll()
+	return j, true, nil
+}
+
+// Store the fetched article in place of the pending one. If the article
+// was already stored under its canonical URL, libraries holding the pending
+// article are pointed at the stored one instead.
+func (repo *Repo) CompleteJob(ctx context.Context, j Job, art *Article) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if art.Url != j.Url {
+		var exists bool
+		row := tx.QueryRowContext(ctx, "SELECT count(*) > 0 FROM articles WHERE url = ?", art.Url)
+		if err := row.Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			_, err = tx.ExecContext(ctx, "UPDATE OR IGNORE library SET url = ? WHERE url = ?", art.Url, j.Url)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "DELETE FROM articles WHERE url = ?", j.Url)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "DELETE FROM jobs WHERE id = ?", j.Id)
+			if err != nil {
+				return err
+			}
+			if err := addAliases(ctx, tx, art.Url, append([]string{j.Url}, art.Aliases...)); err != nil {
+				return err
+			}
+			return repo.commitAndNotifyAll(tx)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE articles SET url = ? WHERE url = ?", art.Url, j.Url)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE articles SET title = ?, contents = ?, summary = NULLIF(?, ''), status = ?, fetchError = NULL WHERE url = ?",
+		art.Title, art.Contents, art.Summary, StatusReady, art.Url)
+	if err != nil {
+		return err
+	}
+	if err := addAliases(ctx, tx, art.Url, art.Aliases); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM jobs WHERE id = ?", j.Id)
+	if err != nil {
+		return err
+	}
+	return repo.commitAndNotifyAll(tx)
+}
+
+// Record a failed attempt at a job. The job is retried after the given
+// delay, or abandoned and the article marked failed if the delay is zero.
+func (repo *Repo) FailJob(ctx context.Context, j Job, message string, retry time.Duration) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	status := StatusPending
+	if retry > 0 {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE jobs SET running = false, attempts = attempts + 1, lastError = ?,
+			  nextAttempt = datetime('now', ?)
+			WHERE id = ?`,
+			message, fmt.Sprintf("+%d seconds", int(retry.Seconds())), j.Id)
+	} else {
+		status = StatusFailed
+		_, err = tx.ExecContext(ctx, "DELETE FROM jobs WHERE id = ?", j.Id)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE articles SET status = ?, fetchError = ? WHERE url = ?",
+		status, message, j.Url)
+	if err != nil {
+		return err
+	}
+	return repo.commitAndNotifyAll(tx)
+}
+
+// Commit a change to articles that may be in anyone's library
+func (repo *Repo) commitAndNotifyAll(tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	repo.changes.NotifyAll()
+	return nil
+}
+
+// Articles that are the same article, to be merged into the one kept
+// and stored under the canonical url
+type Merge struct {
+	Keep       string
+	Duplicates []string
+	Canonical  string
+}
+
+// Merge each group of duplicate articles, all at once, and return the id of
+// the run, which UndoMerge takes. The libraries holding a duplicate are
+// pointed at the article kept; where a library holds both, the entry kept
+// takes the earliest created and latest lastAccess of the two, is read if
+// either was, and is only in the trash if both were. The duplicates' urls become aliases of the article kept.
+// The rows changed are backed up first so that the run can be undone.
+func (repo *Repo) MergeArticles(ctx context.Context, merges []Merge) (int64, error) {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var run int64
+	row := tx.QueryRowContext(ctx, "INSERT INTO mergeRuns DEFAULT VALUES RETURNING id")
+	if err := row.Scan(&run); err != nil {
+		return 0, err
+	}
+	for _, m := range merges {
+		if err := mergeArticles(ctx, tx, run, m); err != nil {
+			return 0, fmt.Errorf("merging into %s: %w", m.Canonical, err)
+		}
+	}
+	return run, repo.commitAndNotifyAll(tx)
+}
+
+// Statements backing up the rows for the urls in the JSON array ?2 in run ?1
+// before they are merged into ?3
+var mergeBackupStatements = []string{
+	`INSERT INTO mergeBackupArticles (run, mergedInto, id, url, contents, title, created, lastModified, status, fetchError, summary)
+	 SELECT ?1, ?3, rowid, url, contents, title, created, lastModified, status, fetchError, summary
+	 FROM articles WHERE url IN (SELECT value FROM json_each(?2))`,
+	`INSERT INTO mergeBackupLibrary (run, user, url, unread, archived, created, lastAccess, lastModified,
+	   unreadChanged, unreadDevice, archivedChanged, archivedDevice, trashed)
+	 SELECT ?1, user, url, unread, archived, created, lastAccess, lastModified,
+	   unreadChanged, unreadDevice, archivedChanged, archivedDevice, trashed
+	 FROM library WHERE url IN (SELECT value FROM json_each(?2))`,
+	`INSERT INTO mergeBackupArticleTags (run, tag, url)
+	 SELECT ?1, tag, url FROM articleTags WHERE url IN (SELECT value FROM json_each(?2))`,
+	`INSERT INTO mergeBackupAliases (run, alias, url)
+	 SELECT ?1, alias, url FROM aliases WHERE url IN (SELECT value FROM json_each(?2))`,
+}
+
+func mergeArticles(ctx context.Context, tx *sql.Tx, run int64, m Merge) error {
+	urls, err := json.Marshal(append([]string{m.Keep}, m.Duplicates...))
+	if err != nil {
+		return err
+	}
+	for _, stmt := range mergeBackupStatements {
+		if _, err := tx.ExecContext(ctx, stmt, run, string(urls), m.Canonical); err != nil {
+			return err
+		}
+	}
+
+	for _, dup := range m.Duplicates {
+		for _, stmt := range []string{
+			// Combine the entries of libraries holding both
+			`UPDATE library AS k SET
+			   created = min(k.created, d.created),
+			   lastAccess = max(k.lastAccess, d.lastAccess),
+			   unread = k.unread AND d.unread,
+			   unreadChanged = max(k.unreadChanged, d.unreadChanged),
+			   unreadDevice = CASE WHEN d.unreadChanged > k.unreadChanged THEN d.unreadDevice ELSE k.unreadDevice END,
+			   trashed = CASE WHEN k.trashed IS NULL OR d.trashed IS NULL THEN NULL ELSE max(k.trashed, d.trashed) END
+			 FROM library AS d WHERE d.url = ?2 AND k.url = ?1 AND k.user = d.user`,
+			"INSERT OR IGNORE INTO articleTags (tag, url) SELECT tag, ?1 FROM articleTags WHERE url = ?2",
+			// Move those of libraries holding only the duplicate
+			"UPDATE OR IGNORE library SET url = ?1 WHERE url = ?2",
+			"UPDATE articles SET created = min(created, (SELECT created FROM articles WHERE url = ?2)) WHERE url = ?1",
+			"UPDATE aliases SET url = ?1 WHERE url = ?2",
+			"DELETE FROM articles WHERE url = ?2",
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, m.Keep, dup); err != nil {
+				return err
+			}
+		}
+		if err := addAliases(ctx, tx, m.Keep, []string{dup}); err != nil {
+			return err
+		}
+	}
+	if m.Keep != m.Canonical {
+		_, err = tx.ExecContext(ctx, "UPDATE articles SET url = ? WHERE url = ?", m.Canonical, m.Keep)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put the articles merged by a run back as they were before it. Changes
+// made to the merged articles since are lost.
+func (repo *Repo) UndoMerge(ctx context.Context, run int64) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var undone bool
+	row := tx.QueryRowContext(ctx, "SELECT undone IS NOT NULL FROM mergeRuns WHERE id = ?", run)
+	if err := row.Scan(&undone); err == sql.ErrNoRows {
+		return fmt.Errorf("no such run: %d", run)
+	} else if err != nil {
+		return err
+	}
+	if undone {
+		return fmt.Errorf("run %d has already been undone", run)
+	}
+
+	for _, stmt := range []string{
+		// Deleting the merged articles takes their library entries, tags
+		// and aliases with them
+		"DELETE FROM articles WHERE url IN (SELECT mergedInto FROM mergeBackupArticles WHERE run = ?1)",
+		"DELETE FROM articles WHERE url IN (SELECT url FROM mergeBackupArticles WHERE run = ?1)",
+		`INSERT INTO articles (rowid, url, contents, title, created, lastModified, status, fetchError, summary)
+		 SELECT id, url, contents, title, created, lastModified, status, fetchError, summary
+		 FROM mergeBackupArticles WHERE run = ?1`,
+		`INSERT OR REPLACE INTO library (user, url, unread, archived, created, lastAccess, lastModified,
+		   unreadChanged, unreadDevice, archivedChanged, archivedDevice, trashed)
+		 SELECT user, url, unread, archived, created, lastAccess, lastModified,
+		   unreadChanged, unreadDevice, archivedChanged, archivedDevice, trashed
+		 FROM mergeBackupLibrary WHERE run = ?1`,
+		"INSERT OR IGNORE INTO articleTags (tag, url) SELECT tag, url FROM mergeBackupArticleTags WHERE run = ?1",
+		"INSERT OR REPLACE INTO aliases (alias, url) SELECT alias, url FROM mergeBackupAliases WHERE run = ?1",
+		"UPDATE mergeRuns SET undone = current_timestamp WHERE id = ?1",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, run); err != nil {
+			return err
+		}
+	}
+	return repo.commitAndNotifyAll(tx)
+}
+
+// Counts of what the database holds
+type Stats struct {
+	// Articles by status
+	Articles       map[string]int
+	Users          int
+	LibraryEntries int
+	Unread         int
+	Archived       int
+	Trashed        int
+	Tags           int
+	Jobs           int
+	Tombstones     int
+}
+
+func (repo *Repo) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Articles: map[string]int{}}
+	rows, err := repo.db.QueryContext(ctx, "SELECT status, count(*) FROM articles GROUP BY status")
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return stats, err
+		}
+		stats.Articles[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	row := repo.db.QueryRowContext(ctx, `
+		SELECT
+		  (SELECT count(DISTINCT user) FROM library),
+		  (SELECT count(*) FROM library),
+		  (SELECT count(*) FROM library WHERE unread),
+		  (SELECT count(*) FROM library WHERE archived),
+		  (SELECT count(*) FROM library WHERE trashed IS NOT NULL),
+		  (SELECT count(*) FROM tags),
+		  (SELECT count(*) FROM jobs),
+		  (SELECT count(*) FROM tombstones)`)
+	err = row.Scan(&stats.Users, &stats.LibraryEntries, &stats.Unread, &stats.Archived, &stats.Trashed,
+		&stats.Tags, &stats.Jobs, &stats.Tombstones)
+	return stats, err
+}
+
+// Merge the segments of the full-text indexes and rebuild the database file
+// without its free pages. The database is locked while this runs.
+func (repo *Repo) Vacuum(ctx context.Context) error {
+	for _, stmt := range []string{
+		"INSERT INTO fts(fts) VALUES('optimize')",
+		"INSERT INTO tagFts(tagFts) VALUES('optimize')",
+		"VACUUM",
+	} {
+		if _, err := repo.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcbilson/readlater/sqlite"
+	"github.com/rcbilson/readlater/www"
+	"gotest.tools/assert"
+)
+
+// Databases created before libraries were per-user keep their articles
+func TestLibraryMigration(t *testing.T) {
+	dbfile := filepath.Join(t.TempDir(), "readlater.db")
+	old, err := sqlite.NewFromFile(dbfile, schema[:3])
+	assert.NilError(t, err)
+	_, err = old.Exec(`INSERT INTO articles (url, title, contents, unread, archived)
+		VALUES ('https://example.com/a', 'Article A', 'all about aardvarks', false, false),
+		       ('https://example.com/b', 'Article B', 'all about baboons', true, true)`)
+	assert.NilError(t, err)
+	old.Close()
+
+	db, err := NewRepo(dbfile, nil)
+	assert.NilError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	owner := User("owner@example.com")
+	list, _, err := db.ListArticles(ctx, owner, ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	assert.NilError(t, db.ClaimLegacyArticles(ctx, owner))
+	list, _, err = db.ListArticles(ctx, owner, ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(list))
+	for _, entry := range list {
+		switch entry.Url {
+		case "https://example.com/a":
+			assert.Assert(t, !entry.Unread && !entry.Archived)
+		case "https://example.com/b":
+			assert.Assert(t, entry.Unread && entry.Archived)
+		}
+	}
+
+	// the full text index survives the migration
+	list, err = db.Search(ctx, owner, "baboons")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "https://example.com/b", list[0].Url)
+}
+
+// Tools such as canonicalize rename and delete article rows directly
+func TestLibraryFollowsArticles(t *testing.T) {
+	db, err := NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := User("test@example.com")
+
+	assert.NilError(t, db.Insert(ctx, user, &Article{Url: "https://example.com/a?utm_source=x", Title: "A", Contents: "a"}))
+	assert.NilError(t, db.Insert(ctx, user, &Article{Url: "https://example.com/b", Title: "B", Contents: "b"}))
+
+	_, err = db.db.Exec("UPDATE articles SET url = 'https://example.com/a' WHERE url = 'https://example.com/a?utm_source=x'")
+	assert.NilError(t, err)
+	_, err = db.db.Exec("DELETE FROM articles WHERE url = 'https://example.com/b'")
+	assert.NilError(t, err)
+
+	list, _, err := db.ListArticles(ctx, user, ListQuery{Sort: "created"})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "https://example.com/a", list[0].Url)
+
+	var count int
+	assert.NilError(t, db.db.QueryRow("SELECT count(*) FROM library").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+// Clients syncing changes learn about articles that left the library
+func TestTombstones(t *testing.T) {
+	db, err := NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := User("test@example.com")
+	other := User("other@example.com")
+	since := "2000-01-01 00:00:00"
+
+	assert.NilError(t, db.Insert(ctx, user, &Article{Url: "https://example.com/a?utm_source=x", Title: "A", Contents: "a"}))
+	assert.NilError(t, db.Insert(ctx, user, &Article{Url: "https://example.com/b", Title: "B", Contents: "b"}))
+	assert.NilError(t, db.AddToLibrary(ctx, other, "https://example.com/b"))
+
+	_, err = db.db.Exec("UPDATE articles SET url = 'https://example.com/a' WHERE url = 'https://example.com/a?utm_source=x'")
+	assert.NilError(t, err)
+	_, err = db.db.Exec("DELETE FROM articles WHERE url = 'https://example.com/b'")
+	assert.NilError(t, err)
+
+	deleted := func(user User) []string {
+		list, err := db.GetChangesSince(ctx, user, since)
+		assert.NilError(t, err)
+		var urls []string
+		for _, entry := range list {
+			if entry.Deleted {
+				urls = append(urls, entry.Url)
+			}
+		}
+		slices.Sort(urls)
+		return urls
+	}
+	assert.DeepEqual(t, []string{"https://example.com/a?utm_source=x", "https://example.com/b"}, deleted(user))
+	assert.DeepEqual(t, []string{"https://example.com/b"}, deleted(other))
+
+	// adding an article again takes back its deletion
+	assert.NilError(t, db.Insert(ctx, other, &Article{Url: "https://example.com/b", Title: "B", Contents: "b"}))
+	assert.Equal(t, 0, len(deleted(other)))
+	list, err := db.GetChangesSince(ctx, other, since)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	// old deletions are forgotten
+	_, err = db.db.Exec("UPDATE tombstones SET deleted = datetime('now', '-2 days') WHERE url = 'https://example.com/b'")
+	assert.NilError(t, err)
+	assert.NilError(t, db.PruneTombstones(ctx, 24*time.Hour))
+	since = time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	assert.DeepEqual(t, []string{"https://example.com/a?utm_source=x"}, deleted(user))
+
+	// clients that last synced before then have to sync afresh, but other
+	// users' clients don't
+	_, err = db.GetChangesSince(ctx, user, "2000-01-01 00:00:00")
+	assert.Assert(t, errors.Is(err, ErrChangesPruned))
+	_, err = db.GetChangesSince(ctx, other, "2000-01-01 00:00:00")
+	assert.NilError(t, err)
+	_, _, err = db.GetChangesAfter(ctx, user, 1)
+	assert.Assert(t, errors.Is(err, ErrChangesPruned))
+	_, _, err = db.GetChangesAfter(ctx, user, 0)
+	assert.NilError(t, err)
+	_, _, err = db.GetChangesAfter(ctx, other, 1)
+	assert.NilError(t, err)
+}
+
+// Rows from before the change sequence get positions of their own
+func TestChangeSequenceMigration(t *testing.T) {
+	dbfile := filepath.Join(t.TempDir(), "readlater.db")
+	old, err := sqlite.NewFromFile(dbfile, schema[:11])
+	assert.NilError(t, err)
+	_, err = old.Exec(`INSERT INTO articles (url, title, contents) VALUES
+		('https://example.com/a', 'Article A', 'all about aardvarks'),
+		('https://example.com/b', 'Article B', 'all about baboons');
+		INSERT INTO library (user, url) VALUES
+		('owner@example.com', 'https://example.com/a'),
+		('owner@example.com', 'https://example.com/b')`)
+	assert.NilError(t, err)
+	old.Close()
+
+	db, err := NewRepo(dbfile, nil)
+	assert.NilError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	var distinct int
+	assert.NilError(t, db.db.QueryRow(`SELECT count(DISTINCT seq) FROM
+		(SELECT seq FROM articles UNION ALL SELECT seq FROM library)`).Scan(&distinct))
+	assert.Equal(t, 4, distinct)
+
+	list, next, more, err := db.GetContentChangesAfter(ctx, User("owner@example.com"), 0, 0, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, more)
+	assert.Equal(t, 1, len(list))
+	list, _, more, err = db.GetContentChangesAfter(ctx, User("owner@example.com"), next, 0, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, !more)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Contents != "")
+}
+
+// Every url an article has been seen under finds it
+func TestAliases(t *testing.T) {
+	db, err := NewTestRepo()
+	assert.NilError(t, err)
+	ctx := context.Background()
+	user := User("test@example.com")
+
+	mux := http.NewServeMux()
+	mux.Handle("/short", http.RedirectHandler("/hop", http.StatusFound))
+	mux.Handle("/hop", http.RedirectHandler("/story?utm_source=feed", http.StatusFound))
+	mux.HandleFunc("/story", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><head><title>Story</title></head><body>words</body></html>"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	summarizer := func(_ context.Context, _ string, html []byte) (Summary, error) {
+		return Summary{Contents: string(html)}, nil
+	}
+
+	art, existing, err := FetchArticle(ctx, db, summarizer, www.Fetcher, server.URL+"/short", "")
+	assert.NilError(t, err)
+	assert.Assert(t, !existing)
+	canonical := strings.Replace(server.URL, "http:", "https:", 1) + "/story"
+	assert.Equal(t, canonical, art.Url)
+	assert.NilError(t, db.Insert(ctx, user, art))
+
+	for _, u := range []string{server.URL + "/short", server.URL + "/hop", server.URL + "/story?utm_source=feed", server.URL + "/story", canonical} {
+		found, ok := db.GetWithoutUpdating(ctx, u)
+		assert.Assert(t, ok, u)
+		assert.Equal(t, canonical, found.Url)
+	}
+
+	// the library is changed through any of them
+	assert.NilError(t, db.SetArchive(ctx, user, server.URL+"/hop", true))
+	assert.NilError(t, db.MarkRead(ctx, user, server.URL+"/short"))
+	list, err := db.Archive(ctx, user, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Assert(t, list[0].Archived)
+	assert.Assert(t, !list[0].Unread)
+
+	// fetching it again by any of them finds the stored article
+	art, existing, err = FetchArticle(ctx, db, summarizer, www.Fetcher, server.URL+"/short", "")
+	assert.NilError(t, err)
+	assert.Assert(t, existing)
+	assert.Equal(t, canonical, art.Url)
+
+	// merged duplicates and renamed articles are found by their old urls
+	assert.NilError(t, db.Insert(ctx, user, &Article{Url: "https://example.com/a", Title: "A", Contents: "a"}))
+	assert.NilError(t, db.Insert(ctx, user, &Article{Url: "https://example.com/b", Title: "B", Contents: "b"}))
+	_, err = db.MergeArticles(ctx, []Merge{{Keep: "https://example.com/a", Duplicates: []string{"https://example.com/b"}, Canonical: "https://example.com/c"}})
+	assert.NilError(t, err)
+	for _, u := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		found, ok := db.LibraryArticle(ctx, user, u)
+		assert.Assert(t, ok, u)
+		assert.Equal(t, "https://example.com/c", found.Url)
+		assert.Equal(t, "a", found.Contents)
+	}
+
+	// storing an article under an alias finds the one already stored
+	dup := &Article{Url: "https://example.com/b", Title: "B", Contents: "b"}
+	assert.NilError(t, db.Insert(ctx, user, dup))
+	assert.Equal(t, "https://example.com/c", dup.Url)
+	found, ok := db.GetWithoutUpdating(ctx, "https://example.com/b")
+	assert.Assert(t, ok)
+	assert.Equal(t, "a", found.Contents)
+
+	// articles saved before the https and www rules are found by the urls
+	// those rules give them
+	_, err = db.DB().Exec("INSERT INTO articles (url, title, contents, status) VALUES (?, 'Old', 'old', ?)", "http://www.example.com/old", StatusReady)
+	assert.NilError(t, err)
+	for _, u := range []string{"https://example.com/old", "http://example.com/old?utm_source=x", "https://www.example.com/old"} {
+		found, ok := db.GetWithoutUpdating(ctx, u)
+		assert.Assert(t, ok, u)
+		assert.Equal(t, "http://www.example.com/old", found.Url)
+	}
+}
@@ -0,0 +1,512 @@
+package library
+
+var schema = []string{
+	// version 1
+	`
+CREATE TABLE metadata (
+  id integer primary key,
+  schemaVersion integer
+);
+
+CREATE TABLE articles (
+  url text primary key,
+  contents text,
+  title text,
+  unread boolean default true,
+  archived boolean default false,
+  created datetime default current_timestamp,
+  lastAccess datetime default current_timestamp
+);
+
+CREATE TABLE usage (
+  timestamp datetime default current_timestamp,
+  url text,
+  lengthIn integer,
+  lengthOut integer,
+  tokensIn integer,
+  tokensOut integer
+);
+
+CREATE VIRTUAL TABLE fts USING fts5(
+  url UNINDEXED,
+  title,
+  contents,
+  content='articles',
+  prefix='1 2 3',
+  tokenize='porter unicode61'
+);
+
+-- Triggers to keep the FTS index up to date.
+CREATE TRIGGER articles_ai AFTER INSERT ON articles BEGIN
+  INSERT INTO fts(rowid, url, title, contents) VALUES (new.rowid, new.url, new.title, new.contents);
+END;
+
+CREATE TRIGGER articles_ad AFTER DELETE ON articles BEGIN
+  INSERT INTO fts(fts, rowid, url, title, contents) VALUES('delete', old.rowid, old.url, old.title, old.contents);
+END;
+
+CREATE TRIGGER articles_au AFTER UPDATE ON articles BEGIN
+  INSERT INTO fts(fts, rowid, url, title, contents) VALUES('delete', old.rowid, old.url, old.title, old.contents);
+  INSERT INTO fts(rowid, url, title, contents) VALUES (new.rowid, new.url, new.title, new.contents);
+END;
+	`,
+        // version 2
+        `
+CREATE INDEX articles_lastAccess ON articles(lastAccess);
+CREATE INDEX articles_created ON articles(created);
+        `,
+	// version 3
+	`
+ALTER TABLE articles ADD COLUMN lastModified datetime;
+
+UPDATE articles SET lastModified = current_timestamp WHERE lastModified IS NULL;
+
+CREATE TRIGGER articles_update_modified
+AFTER UPDATE ON articles
+BEGIN
+  UPDATE articles SET lastModified = current_timestamp WHERE url = NEW.url;
+END;
+
+CREATE INDEX articles_lastModified ON articles(lastModified);
+	`,
+	// version 4
+	`
+CREATE TABLE sessions (
+  token text primary key,
+  user text not null,
+  created datetime default current_timestamp,
+  expires datetime not null
+);
+
+CREATE INDEX sessions_expires ON sessions(expires);
+	`,
+	// version 5
+	`
+-- Article contents are shared between users; the state of an article in a
+-- user's library is kept separately.
+CREATE TABLE library (
+  user text not null,
+  url text not null,
+  unread boolean default true,
+  archived boolean default false,
+  created datetime default current_timestamp,
+  lastAccess datetime default current_timestamp,
+  lastModified datetime default current_timestamp,
+  primary key (user, url)
+);
+
+-- Articles saved before libraries were per-user belong to the empty user
+-- until claimed.
+INSERT INTO library (user, url, unread, archived, created, lastAccess, lastModified)
+  SELECT '', url, unread, archived, created, lastAccess, lastModified FROM articles;
+
+CREATE INDEX library_lastAccess ON library(user, lastAccess);
+CREATE INDEX library_created ON library(user, created);
+CREATE INDEX library_lastModified ON library(user, lastModified);
+CREATE INDEX library_url ON library(url);
+
+CREATE TRIGGER library_update_modified
+AFTER UPDATE ON library
+BEGIN
+  UPDATE library SET lastModified = current_timestamp WHERE user = NEW.user AND url = NEW.url;
+END;
+
+CREATE TRIGGER articles_delete_library AFTER DELETE ON articles BEGIN
+  DELETE FROM library WHERE url = old.url;
+END;
+
+CREATE TRIGGER articles_rename_library AFTER UPDATE OF url ON articles BEGIN
+  UPDATE OR REPLACE library SET url = new.url WHERE url = old.url;
+END;
+
+DROP INDEX articles_lastAccess;
+ALTER TABLE articles DROP COLUMN unread;
+ALTER TABLE articles DROP COLUMN archived;
+ALTER TABLE articles DROP COLUMN lastAccess;
+	`,
+	// version 6
+	`
+CREATE TABLE apiTokens (
+  id integer primary key,
+  user text not null,
+  name text,
+  token text not null unique,
+  scopes text not null,
+  created datetime default current_timestamp,
+  lastUsed datetime
+);
+
+CREATE INDEX apiTokens_user ON apiTokens(user);
+	`,
+	// version 7
+	`
+-- Articles added asynchronously exist before their contents do
+ALTER TABLE articles ADD COLUMN status text not null default 'ready';
+ALTER TABLE articles ADD COLUMN fetchError text;
+
+CREATE TABLE jobs (
+  id integer primary key,
+  url text not null,
+  user text not null,
+  titleHint text,
+  attempts integer not null default 0,
+  nextAttempt datetime default current_timestamp,
+  running boolean default false,
+  lastError text,
+  created datetime default current_timestamp
+);
+
+CREATE INDEX jobs_nextAttempt ON jobs(nextAttempt);
+
+-- Reindex only when the indexed columns change. Otherwise the nested update
+-- made by articles_update_modified deletes index entries using the new
+-- values before they have been indexed, corrupting the index.
+DROP TRIGGER articles_au;
+CREATE TRIGGER articles_au AFTER UPDATE OF title, contents ON articles BEGIN
+  INSERT INTO fts(fts, rowid, url, title, contents) VALUES('delete', old.rowid, old.url, old.title, old.contents);
+  INSERT INTO fts(rowid, url, title, contents) VALUES (new.rowid, new.url, new.title, new.contents);
+END;
+	`,
+	// version 8
+	`
+-- A short synopsis written by the LLM summarizer
+ALTER TABLE articles ADD COLUMN summary text;
+	`,
+	// version 9
+	`
+ALTER TABLE usage ADD COLUMN model text;
+
+CREATE INDEX usage_timestamp ON usage(timestamp);
+	`,
+	// version 10
+	`
+-- Articles that have left a user's library, so that clients syncing
+-- changes learn to drop them. Deleting an article deletes it from every
+-- library, and renaming one removes the old url from them.
+CREATE TABLE tombstones (
+  user text not null,
+  url text not null,
+  deleted datetime default current_timestamp,
+  primary key (user, url)
+);
+
+CREATE INDEX tombstones_deleted ON tombstones(user, deleted);
+
+CREATE TRIGGER library_tombstone AFTER DELETE ON library BEGIN
+  INSERT OR REPLACE INTO tombstones (user, url) VALUES (old.user, old.url);
+END;
+
+CREATE TRIGGER library_rename_tombstone AFTER UPDATE OF url ON library BEGIN
+  INSERT OR REPLACE INTO tombstones (user, url) VALUES (old.user, old.url);
+  DELETE FROM tombstones WHERE user = new.user AND url = new.url;
+END;
+
+CREATE TRIGGER library_resurrect AFTER INSERT ON library BEGIN
+  DELETE FROM tombstones WHERE user = new.user AND url = new.url;
+END;
+
+-- The time of the latest deletion from each user's library that has been
+-- forgotten. Clients that last synced before then may have missed
+-- deletions, so have to sync afresh.
+CREATE TABLE tombstonesPruned (
+  user text primary key,
+  deleted datetime not null
+);
+	`,
+	// version 11
+	`
+-- When each field of a library entry last changed, in milliseconds since
+-- the epoch, and the device that changed it, for resolving conflicting
+-- changes from clients that were offline. Changes made before these were
+-- kept lose to any later change.
+ALTER TABLE library ADD COLUMN unreadChanged integer not null default 0;
+ALTER TABLE library ADD COLUMN unreadDevice text not null default '';
+ALTER TABLE library ADD COLUMN archivedChanged integer not null default 0;
+ALTER TABLE library ADD COLUMN archivedDevice text not null default '';
+	`,
+	// version 12
+	`
+-- Every change to an article, a library entry or a tombstone takes the
+-- next number in the change sequence, so that clients can ask for exactly
+-- the changes they haven't seen. Rows that existed before this have
+-- sequence number 1.
+CREATE TABLE changeSequence (
+  id integer primary key,
+  value integer not null
+);
+
+INSERT INTO changeSequence (id, value) VALUES (0, 1);
+
+ALTER TABLE articles ADD COLUMN seq integer not null default 1;
+ALTER TABLE library ADD COLUMN seq integer not null default 1;
+ALTER TABLE tombstones ADD COLUMN seq integer not null default 1;
+ALTER TABLE tombstonesPruned ADD COLUMN seq integer not null default 1;
+
+CREATE INDEX articles_seq ON articles(seq);
+CREATE INDEX library_seq ON library(user, seq);
+CREATE INDEX tombstones_seq ON tombstones(user, seq);
+
+CREATE TRIGGER articles_seq_insert AFTER INSERT ON articles BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE articles SET seq = (SELECT value FROM changeSequence WHERE id = 0) WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER articles_seq_update AFTER UPDATE OF url, title, contents, summary, status ON articles BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE articles SET seq = (SELECT value FROM changeSequence WHERE id = 0) WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER library_seq_insert AFTER INSERT ON library BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0) WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER library_seq_update AFTER UPDATE OF user, url, unread, archived, lastAccess ON library BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0) WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER tombstones_seq_insert AFTER INSERT ON tombstones BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE tombstones SET seq = (SELECT value FROM changeSequence WHERE id = 0) WHERE rowid = new.rowid;
+END;
+	`,
+	// version 13
+	`
+-- When an article's contents last changed and when an article was added to
+-- a library, in the change sequence, so that the changes feed only sends
+-- contents to clients that haven't seen them.
+ALTER TABLE articles ADD COLUMN contentSeq integer not null default 0;
+ALTER TABLE library ADD COLUMN addedSeq integer not null default 0;
+
+-- Give the rows from before the change sequence numbers of their own, so
+-- that a position in the sequence is a position in any feed. The triggers
+-- that record modification times are dropped meanwhile, since nothing has
+-- really been modified.
+DROP TRIGGER articles_update_modified;
+DROP TRIGGER library_update_modified;
+
+UPDATE articles SET seq = (SELECT value FROM changeSequence WHERE id = 0) + rowid WHERE seq = 1;
+UPDATE changeSequence SET value = value + COALESCE((SELECT max(rowid) FROM articles), 0) WHERE id = 0;
+UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0) + rowid WHERE seq = 1;
+UPDATE changeSequence SET value = value + COALESCE((SELECT max(rowid) FROM library), 0) WHERE id = 0;
+UPDATE tombstones SET seq = (SELECT value FROM changeSequence WHERE id = 0) + rowid WHERE seq = 1;
+UPDATE changeSequence SET value = value + COALESCE((SELECT max(rowid) FROM tombstones), 0) WHERE id = 0;
+
+UPDATE articles SET contentSeq = seq;
+UPDATE library SET addedSeq = seq;
+
+CREATE TRIGGER articles_update_modified
+AFTER UPDATE ON articles
+BEGIN
+  UPDATE articles SET lastModified = current_timestamp WHERE url = NEW.url;
+END;
+
+CREATE TRIGGER library_update_modified
+AFTER UPDATE ON library
+BEGIN
+  UPDATE library SET lastModified = current_timestamp WHERE user = NEW.user AND url = NEW.url;
+END;
+
+DROP TRIGGER articles_seq_insert;
+CREATE TRIGGER articles_seq_insert AFTER INSERT ON articles BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE articles SET seq = (SELECT value FROM changeSequence WHERE id = 0), contentSeq = (SELECT value FROM changeSequence WHERE id = 0)
+  WHERE rowid = new.rowid;
+END;
+
+DROP TRIGGER articles_seq_update;
+CREATE TRIGGER articles_seq_update AFTER UPDATE OF url, title, contents, summary, status ON articles BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE articles SET seq = (SELECT value FROM changeSequence WHERE id = 0),
+    contentSeq = CASE WHEN new.contents IS NOT old.contents THEN (SELECT value FROM changeSequence WHERE id = 0) ELSE contentSeq END
+  WHERE rowid = new.rowid;
+END;
+
+DROP TRIGGER library_seq_insert;
+CREATE TRIGGER library_seq_insert AFTER INSERT ON library BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0), addedSeq = (SELECT value FROM changeSequence WHERE id = 0)
+  WHERE rowid = new.rowid;
+END;
+
+-- An article that moves to a new url is new to clients under that url
+DROP TRIGGER library_seq_update;
+CREATE TRIGGER library_seq_update AFTER UPDATE OF user, url, unread, archived, lastAccess ON library BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0),
+    addedSeq = CASE WHEN new.url IS NOT old.url OR new.user IS NOT old.user THEN (SELECT value FROM changeSequence WHERE id = 0) ELSE addedSeq END
+  WHERE rowid = new.rowid;
+END;
+	`,
+	// version 14
+	`
+-- Tags belong to a user and are attached to articles in their library. A
+-- tag with slashes in its name files articles in a folder, so that listing
+-- the articles with a tag also lists those with the tags nested under it.
+CREATE TABLE tags (
+  id integer primary key,
+  user text not null,
+  name text not null collate nocase,
+  unique (user, name)
+);
+
+CREATE TABLE articleTags (
+  tag integer not null,
+  url text not null,
+  primary key (tag, url)
+);
+
+CREATE INDEX articleTags_url ON articleTags(url);
+
+-- Tags are searched along with titles and contents, but they belong to a
+-- user, so they have an index of their own with a row per library entry.
+CREATE VIRTUAL TABLE tagFts USING fts5(
+  user UNINDEXED,
+  url UNINDEXED,
+  tags,
+  prefix='1 2 3',
+  tokenize='porter unicode61'
+);
+
+-- Tagging an article reindexes its tags and changes its library entry
+CREATE TRIGGER articleTags_ai AFTER INSERT ON articleTags BEGIN
+  DELETE FROM tagFts WHERE user = (SELECT user FROM tags WHERE id = new.tag) AND url = new.url;
+  INSERT INTO tagFts (user, url, tags)
+    SELECT t.user, x.url, group_concat(t.name, ' ') FROM articleTags x INNER JOIN tags t ON t.id = x.tag
+    WHERE x.url = new.url AND t.user = (SELECT user FROM tags WHERE id = new.tag) GROUP BY t.user, x.url;
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0)
+  WHERE user = (SELECT user FROM tags WHERE id = new.tag) AND url = new.url;
+END;
+
+CREATE TRIGGER articleTags_ad AFTER DELETE ON articleTags BEGIN
+  DELETE FROM tagFts WHERE user = (SELECT user FROM tags WHERE id = old.tag) AND url = old.url;
+  INSERT INTO tagFts (user, url, tags)
+    SELECT t.user, x.url, group_concat(t.name, ' ') FROM articleTags x INNER JOIN tags t ON t.id = x.tag
+    WHERE x.url = old.url AND t.user = (SELECT user FROM tags WHERE id = old.tag) GROUP BY t.user, x.url;
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0)
+  WHERE user = (SELECT user FROM tags WHERE id = old.tag) AND url = old.url;
+END;
+
+-- Tags follow an article to its new url and leave with it
+CREATE TRIGGER library_tags_rename AFTER UPDATE OF url ON library BEGIN
+  UPDATE OR IGNORE articleTags SET url = new.url
+  WHERE url = old.url AND tag IN (SELECT id FROM tags WHERE user = new.user);
+  DELETE FROM articleTags WHERE url = old.url AND tag IN (SELECT id FROM tags WHERE user = new.user);
+  DELETE FROM tagFts WHERE user = new.user AND url IN (old.url, new.url);
+  INSERT INTO tagFts (user, url, tags)
+    SELECT t.user, x.url, group_concat(t.name, ' ') FROM articleTags x INNER JOIN tags t ON t.id = x.tag
+    WHERE x.url = new.url AND t.user = new.user GROUP BY t.user, x.url;
+END;
+
+CREATE TRIGGER library_tags_delete AFTER DELETE ON library BEGIN
+  DELETE FROM articleTags WHERE url = old.url AND tag IN (SELECT id FROM tags WHERE user = old.user);
+END;
+	`,
+	// version 15
+	`
+-- Every other url an article has been seen under: the one it was saved
+-- with, the redirects on the way to it, and those of duplicates merged into
+-- it. Looking an article up by any of them finds it.
+CREATE TABLE aliases (
+  alias text primary key,
+  url text not null
+);
+
+CREATE INDEX aliases_url ON aliases(url);
+
+-- A renamed article is still found by its old url, and an article stored
+-- under a url is no longer an alias of another
+CREATE TRIGGER articles_rename_aliases AFTER UPDATE OF url ON articles BEGIN
+  UPDATE aliases SET url = new.url WHERE url = old.url;
+  INSERT OR REPLACE INTO aliases (alias, url) VALUES (old.url, new.url);
+  DELETE FROM aliases WHERE alias = new.url;
+END;
+
+CREATE TRIGGER articles_insert_aliases AFTER INSERT ON articles BEGIN
+  DELETE FROM aliases WHERE alias = new.url;
+END;
+
+CREATE TRIGGER articles_delete_aliases AFTER DELETE ON articles BEGIN
+  DELETE FROM aliases WHERE url = old.url;
+END;
+	`,
+	// version 16
+	`
+-- Runs of canonicalize that merged duplicate articles, and the rows they
+-- changed as they were before, so that a run can be undone
+CREATE TABLE mergeRuns (
+  id integer primary key,
+  created datetime default current_timestamp,
+  undone datetime
+);
+
+CREATE TABLE mergeBackupArticles (
+  run integer not null,
+  -- the url the article was merged into
+  mergedInto text not null,
+  id integer not null,
+  url text not null,
+  contents text,
+  title text,
+  created datetime,
+  lastModified datetime,
+  status text,
+  fetchError text,
+  summary text
+);
+
+CREATE TABLE mergeBackupLibrary (
+  run integer not null,
+  user text not null,
+  url text not null,
+  unread boolean,
+  archived boolean,
+  created datetime,
+  lastAccess datetime,
+  lastModified datetime,
+  unreadChanged integer,
+  unreadDevice text,
+  archivedChanged integer,
+  archivedDevice text
+);
+
+CREATE TABLE mergeBackupArticleTags (
+  run integer not null,
+  tag integer not null,
+  url text not null
+);
+
+CREATE TABLE mergeBackupAliases (
+  run integer not null,
+  alias text not null,
+  url text not null
+);
+
+CREATE INDEX mergeBackupArticles_run ON mergeBackupArticles(run);
+CREATE INDEX mergeBackupLibrary_run ON mergeBackupLibrary(run);
+CREATE INDEX mergeBackupArticleTags_run ON mergeBackupArticleTags(run);
+CREATE INDEX mergeBackupAliases_run ON mergeBackupAliases(run);
+	`,
+	// version 17
+	`
+-- Articles deleted from a library go to the trash, when this is set, and
+-- can be restored until they are purged. Clients syncing changes are told
+-- that trashed articles are deleted and that restored ones are new.
+ALTER TABLE library ADD COLUMN trashed datetime;
+ALTER TABLE mergeBackupLibrary ADD COLUMN trashed datetime;
+
+CREATE INDEX library_trashed ON library(trashed);
+
+DROP TRIGGER library_seq_update;
+CREATE TRIGGER library_seq_update AFTER UPDATE OF user, url, unread, archived, lastAccess, trashed ON library BEGIN
+  UPDATE changeSequence SET value = value + 1 WHERE id = 0;
+  UPDATE library SET seq = (SELECT value FROM changeSequence WHERE id = 0),
+    addedSeq = CASE WHEN new.url IS NOT old.url OR new.user IS NOT old.user OR (old.trashed IS NOT NULL AND new.trashed IS NULL)
+      THEN (SELECT value FROM changeSequence WHERE id = 0) ELSE addedSeq END
+  WHERE rowid = new.rowid;
+END;
+	`,
+}
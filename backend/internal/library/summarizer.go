@@ -0,0 +1,47 @@
+package library
+
+import (
+	"context"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/rcbilson/readlater/www"
+)
+
+// What a summarizer makes of an article
+type Summary struct {
+	Contents string // the whole article as markdown
+	Tldr     string // a short synopsis, if the summarizer writes one
+}
+
+type SummarizeFunc func(ctx context.Context, url string, article []byte) (Summary, error)
+
+// Converts the article to markdown, keeping only its main content
+func HtmlToMarkdownSummarizer() SummarizeFunc {
+	return func(ctx context.Context, url string, article []byte) (Summary, error) {
+		converter := md.NewConverter("", true, nil)
+
+		// Configure options for better conversion
+		converter.Use(md.Plugin(func(c *md.Converter) []md.Rule {
+			return []md.Rule{
+				// Custom rules can be added here if needed
+			}
+		}))
+
+		// Keep only the main content of the page, if we can find it
+		if readable, err := www.Extract(article); err == nil {
+			article = readable.HTML()
+		}
+
+		// Convert HTML to markdown
+		markdown, err := converter.ConvertString(string(article))
+		if err != nil {
+			return Summary{}, err
+		}
+
+		// Clean up extra whitespace and normalize line endings
+		markdown = strings.TrimSpace(markdown)
+
+		return Summary{Contents: markdown}, nil
+	}
+}
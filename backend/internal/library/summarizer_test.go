@@ -1,4 +1,4 @@
-package main
+package library
 
 import (
 	"context"
@@ -31,7 +31,7 @@ func TestHtmlToMarkdown(t *testing.T) {
 		"https://tastecooking.com/is-it-soft-tofus-time/",
 	}
 
-	summarizer := htmlToMarkdownSummarizer()
+	summarizer := HtmlToMarkdownSummarizer()
 
 	for _, url := range urls {
 		base := filepath.Base(url)
@@ -50,12 +50,12 @@ func TestHtmlToMarkdown(t *testing.T) {
 				continue
 			}
 		}
-		contents, err := summarizer(context.Background(), bytes)
+		summary, err := summarizer(context.Background(), url, bytes)
 		if err != nil {
 			t.Errorf("%s: error from html-to-markdown: %v", url, err)
 		}
 		// save contents for possible analysis
 		mdPath := strings.TrimSuffix(htmlPath, ".html") + ".md"
-		saveFile(t, mdPath, []byte(contents))
+		saveFile(t, mdPath, []byte(summary.Contents))
 	}
 }
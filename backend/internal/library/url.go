@@ -0,0 +1,335 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rcbilson/readlater/www"
+)
+
+// CanonicalRules decide when urls are the same article, so that an article
+// is stored once however its url arrives. URLs come with tracking
+// parameters from links that were shared, redirects and AMP caches, and
+// without canonicalization the sync system could look an article up under
+// a url it isn't stored under, making it unavailable offline. The frontend
+// no longer needs to canonicalize urls since the backend does.
+//
+// Query parameters are kept unless they are known to be for tracking, since
+// on many sites the query identifies the page: ?id= on Hacker News, ?v= on
+// YouTube and ?p= in many blogs.
+type CanonicalRules struct {
+	// Query parameters stripped from every url. A trailing * matches any
+	// parameter starting with what comes before it.
+	Strip []string `json:"strip"`
+	// Rewrite http urls to https
+	Https bool `json:"https"`
+	// Drop a leading www. from hosts
+	StripWww bool `json:"stripWww"`
+	// Map AMP pages to the pages they are versions of: those served by AMP
+	// caches, those that say they are AMP pages when fetched and those on
+	// sites whose rules say which of their urls are AMP pages
+	Amp bool `json:"amp"`
+	// Honor the <link rel="canonical"> of a fetched page when it names a
+	// page on the same site
+	LinkCanonical bool `json:"linkCanonical"`
+	// Rules for particular sites
+	Sites []SiteRule `json:"sites"`
+}
+
+// The rules for a site, which also apply to its subdomains
+type SiteRule struct {
+	Domain string `json:"domain"`
+	// The only query parameters kept, because they identify the page
+	Keep []string `json:"keep"`
+	// Query parameters stripped along with those stripped everywhere. A
+	// lone * strips the whole query.
+	Strip []string `json:"strip"`
+	// The site's amp. hosts, /amp paths, .amp.html pages and ?amp queries
+	// are AMP pages, so are mapped to the pages they are versions of
+	// without having to be fetched. Elsewhere those are often ordinary
+	// pages.
+	Amp bool `json:"amp"`
+}
+
+func DefaultCanonicalRules() *CanonicalRules {
+	return &CanonicalRules{
+		Strip: []string{
+			"utm_*", "fbclid", "gclid", "gclsrc", "dclid", "msclkid", "twclid", "yclid",
+			"igshid", "mc_cid", "mc_eid", "_ga", "_gl", "_hsenc", "_hsmi", "mkt_tok",
+			"ref", "ref_src", "ref_url", "smid", "cmpid", "sr_share",
+		},
+		Https:         true,
+		StripWww:      true,
+		Amp:           true,
+		LinkCanonical: true,
+		Sites: []SiteRule{
+			{Domain: "news.ycombinator.com", Keep: []string{"id"}},
+			{Domain: "youtube.com", Keep: []string{"v"}},
+		},
+	}
+}
+
+// Reads rules from a JSON file. Settings the file leaves out keep their
+// defaults.
+func LoadCanonicalRules(path string) (*CanonicalRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := DefaultCanonicalRules()
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("invalid canonicalization rules in %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Canonicalize returns the url an article at rawURL is stored under
+func (rules *CanonicalRules) Canonicalize(rawURL string) (string, error) {
+	return rules.canonicalize(rawURL, false)
+}
+
+// canonicalize is Canonicalize for a url that may be known to be an AMP
+// page
+func (rules *CanonicalRules) canonicalize(rawURL string, amp bool) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return u.String(), nil
+	}
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port == "80" && u.Scheme == "http" || port == "443" && u.Scheme == "https" {
+		u.Host = u.Hostname()
+	}
+
+	site := rules.site(u.Hostname())
+	if rules.Amp {
+		// caches only serve AMP pages
+		if page, ok := ampCachePage(u); ok {
+			return rules.canonicalize(page, true)
+		}
+		if amp || site != nil && site.Amp {
+			dropAmp(u)
+		}
+	}
+	if rules.Https {
+		u.Scheme = "https"
+	}
+	if rules.StripWww {
+		u.Host = trimLabel(u.Host, "www.")
+	}
+
+	query := u.Query()
+	for name := range query {
+		var strip bool
+		if site != nil && len(site.Keep) > 0 {
+			strip = !slices.Contains(site.Keep, name)
+		} else {
+			strip = matchParam(rules.Strip, name) || site != nil && matchParam(site.Strip, name)
+		}
+		if strip {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// CanonicalizePage is Canonicalize for a page fetched from pageURL, which
+// may say what its canonical url is and whether it is an AMP page
+func (rules *CanonicalRules) CanonicalizePage(pageURL string, html []byte) (string, error) {
+	canonical, err := rules.canonicalize(pageURL, rules.Amp && www.IsAmp(html))
+	if err != nil || !rules.LinkCanonical {
+		return canonical, err
+	}
+	link := www.CanonicalLink(html, pageURL)
+	if link == "" {
+		return canonical, nil
+	}
+	linked, err := rules.Canonicalize(link)
+	if err != nil {
+		return canonical, nil
+	}
+	// Some sites name their home page as the canonical url of everything,
+	// and a page has no business claiming to be another site's
+	from, _ := url.Parse(canonical)
+	to, _ := url.Parse(linked)
+	if to.Host == "" || siteName(to.Hostname()) != siteName(from.Hostname()) {
+		return canonical, nil
+	}
+	if strings.Trim(to.Path, "/") == "" && strings.Trim(from.Path, "/") != "" {
+		return canonical, nil
+	}
+	return linked, nil
+}
+
+// Returns the other urls that canonicalize to canonical by the https and
+// www rules alone. Articles saved before those rules were turned on are
+// stored under them until canonicalize moves them.
+func (rules *CanonicalRules) variants(canonical string) []string {
+	u, err := url.Parse(canonical)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil
+	}
+	schemes := []string{"https"}
+	if rules.Https {
+		schemes = append(schemes, "http")
+	}
+	hosts := []string{u.Host}
+	if rules.StripWww && trimLabel("www."+u.Host, "www.") == u.Host {
+		hosts = append(hosts, "www."+u.Host)
+	}
+	var result []string
+	for _, scheme := range schemes {
+		for _, host := range hosts {
+			v := *u
+			v.Scheme, v.Host = scheme, host
+			if s := v.String(); s != canonical {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+func (rules *CanonicalRules) site(host string) *SiteRule {
+	host = strings.TrimPrefix(host, "www.")
+	for i, site := range rules.Sites {
+		domain := strings.TrimPrefix(strings.ToLower(site.Domain), "www.")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return &rules.Sites[i]
+		}
+	}
+	return nil
+}
+
+func matchParam(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == name {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// The host without the prefixes that mark the mobile, AMP or www versions
+// of a site
+func siteName(host string) string {
+	for _, prefix := range []string{"www.", "amp.", "m."} {
+		host = trimLabel(host, prefix)
+	}
+	return host
+}
+
+// Trims a leading label such as www. from the host, unless all that would
+// be left is a top-level domain, as with www.com or amp.dev
+func trimLabel(host string, prefix string) string {
+	if rest, ok := strings.CutPrefix(host, prefix); ok && strings.Contains(rest, ".") {
+		return rest
+	}
+	return host
+}
+
+// Returns the url of the page an AMP cache url serves, such as
+// https://www.google.com/amp/s/example.com/a or
+// https://example-com.cdn.ampproject.org/c/s/example.com/a
+func ampCachePage(u *url.URL) (string, bool) {
+	var rest string
+	switch host := u.Hostname(); {
+	case host == "google.com" || host == "www.google.com":
+		var ok bool
+		if rest, ok = strings.CutPrefix(u.Path, "/amp/"); !ok {
+			return "", false
+		}
+	case strings.HasSuffix(host, ".cdn.ampproject.org"):
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if len(parts) < 2 {
+			return "", false
+		}
+		rest = parts[1]
+	default:
+		return "", false
+	}
+	scheme := "http://"
+	if after, ok := strings.CutPrefix(rest, "s/"); ok {
+		scheme, rest = "https://", after
+	}
+	if rest == "" {
+		return "", false
+	}
+	page := scheme + rest
+	if u.RawQuery != "" {
+		page += "?" + u.RawQuery
+	}
+	return page, true
+}
+
+// Turns the url of an AMP page into that of the page it is a version of
+func dropAmp(u *url.URL) {
+	u.Host = trimLabel(u.Host, "amp.")
+	path := u.Path
+	switch {
+	case strings.HasSuffix(path, ".amp.html"):
+		path = strings.TrimSuffix(path, ".amp.html") + ".html"
+	case strings.HasSuffix(path, "/amp") || strings.HasSuffix(path, "/amp/"):
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), "/amp")
+		if path == "" {
+			path = "/"
+		}
+	case strings.HasPrefix(path, "/amp/"):
+		path = strings.TrimPrefix(path, "/amp")
+	}
+	if path != u.Path {
+		u.Path = path
+		u.RawPath = ""
+	}
+	query := u.Query()
+	if query.Has("amp") || strings.EqualFold(query.Get("outputType"), "amp") {
+		query.Del("amp")
+		query.Del("outputType")
+		u.RawQuery = query.Encode()
+	}
+}
+
+var titleExtractor = regexp.MustCompile(`^# (.*)\n`)
+
+// Finds the title of an article: the heading its markdown starts with, else
+// the hint, the title of the page or, in desperation, its path
+func ExtractTitle(md *string, html []byte, urlString string, titleHint string) string {
+	matches := titleExtractor.FindStringSubmatch(*md)
+	if matches != nil && len(matches) > 1 {
+		return matches[1]
+	}
+
+	// sometimes the browser gives us the title for nothing
+	if titleHint != "" {
+		return titleHint
+	}
+
+	// Try to extract the title from the HTML
+	title := www.HtmlTitle(html)
+	if title != "" {
+		return title
+	}
+
+	// In desperation, use the URL
+	parsedUrl, err := url.Parse(urlString)
+	if err == nil {
+		return parsedUrl.Path
+	} else {
+		return urlString
+	}
+}
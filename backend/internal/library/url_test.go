@@ -0,0 +1,114 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCanonicalize(t *testing.T) {
+	rules := DefaultCanonicalRules()
+	for _, c := range []struct{ in, out string }{
+		// tracking parameters go, others stay, in order
+		{"https://example.com/a?utm_source=x&utm_medium=y", "https://example.com/a"},
+		{"https://example.com/a?utm_source=pocket_shared#section", "https://example.com/a"},
+		{"https://example.com/?p=123&fbclid=abc", "https://example.com/?p=123"},
+		{"https://example.com/search?q=b&page=2&ref=nav", "https://example.com/search?page=2&q=b"},
+		// the query identifies the page on some sites
+		{"https://news.ycombinator.com/item?id=123&goto=news", "https://news.ycombinator.com/item?id=123"},
+		{"https://www.youtube.com/watch?v=abc&t=42s&feature=share", "https://youtube.com/watch?v=abc"},
+		{"https://m.youtube.com/watch?v=abc", "https://m.youtube.com/watch?v=abc"},
+		// scheme, host and port
+		{"http://www.Example.com:80/a", "https://example.com/a"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		// AMP caches, whose pages are all AMP pages
+		{"https://www.google.com/amp/s/www.example.com/story/1/amp", "https://example.com/story/1"},
+		{"https://example-com.cdn.ampproject.org/c/s/example.com/story/1?amp=1", "https://example.com/story/1"},
+		// urls that only look like AMP pages are left alone
+		{"https://amp.example.com/story/1", "https://amp.example.com/story/1"},
+		{"https://example.com/blog/amp", "https://example.com/blog/amp"},
+		{"https://amp.dev/x", "https://amp.dev/x"},
+		{"https://www.com/x", "https://www.com/x"},
+		// other schemes are left alone
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+	} {
+		out, err := rules.Canonicalize(c.in)
+		assert.NilError(t, err)
+		assert.Equal(t, c.out, out, c.in)
+	}
+
+	// unless the site's rules say they are
+	rules.Sites = append(rules.Sites, SiteRule{Domain: "example.com", Amp: true})
+	for _, c := range []struct{ in, out string }{
+		{"https://amp.example.com/story/1", "https://example.com/story/1"},
+		{"https://example.com/story/1/amp/", "https://example.com/story/1"},
+		{"https://example.com/amp/story/1", "https://example.com/story/1"},
+		{"https://example.com/story/1.amp.html", "https://example.com/story/1.html"},
+		{"https://example.com/story/1?amp=1", "https://example.com/story/1"},
+		{"https://example.org/story/1/amp", "https://example.org/story/1/amp"},
+	} {
+		out, err := rules.Canonicalize(c.in)
+		assert.NilError(t, err)
+		assert.Equal(t, c.out, out, c.in)
+	}
+}
+
+func TestCanonicalizePage(t *testing.T) {
+	rules := DefaultCanonicalRules()
+	page := func(href string) []byte {
+		return []byte(`<html><head><title>A</title><link rel="canonical" href="` + href + `"></head><body></body></html>`)
+	}
+	for _, c := range []struct{ pageURL, href, out string }{
+		{"https://example.com/a?session=1", "https://www.example.com/a?utm_source=feed", "https://example.com/a"},
+		{"https://m.example.com/a", "/a/", "https://m.example.com/a/"},
+		{"https://amp.example.com/a", "https://example.com/article/a", "https://example.com/article/a"},
+		// pages can't claim to be the home page or another site's
+		{"https://example.com/a?id=1", "https://example.com/", "https://example.com/a?id=1"},
+		{"https://example.com/a", "https://other.example.org/a", "https://example.com/a"},
+	} {
+		out, err := rules.CanonicalizePage(c.pageURL, page(c.href))
+		assert.NilError(t, err)
+		assert.Equal(t, c.out, out, c.pageURL)
+	}
+
+	rules.LinkCanonical = false
+	out, err := rules.CanonicalizePage("https://example.com/a", page("https://example.com/b"))
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.com/a", out)
+
+	// pages that say they are AMP pages are mapped to the pages they are
+	// versions of even when they don't link to them
+	out, err = rules.CanonicalizePage("https://example.com/story/1/amp", []byte(`<html amp><head></head><body></body></html>`))
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.com/story/1", out)
+	out, err = rules.CanonicalizePage("https://amp.example.com/story/1", []byte(`<html ⚡ lang="en"><head></head><body></body></html>`))
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.com/story/1", out)
+	out, err = rules.CanonicalizePage("https://example.com/blog/amp", []byte(`<html><head></head><body></body></html>`))
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.com/blog/amp", out)
+}
+
+func TestLoadCanonicalRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NilError(t, os.WriteFile(path, []byte(`{
+		"stripWww": false,
+		"sites": [{"domain": "example.com", "strip": ["*"]}]
+	}`), 0o644))
+	rules, err := LoadCanonicalRules(path)
+	assert.NilError(t, err)
+
+	// what the file leaves out keeps its default
+	out, err := rules.Canonicalize("http://www.example.com/a?p=1")
+	assert.NilError(t, err)
+	assert.Equal(t, "https://www.example.com/a", out)
+	out, err = rules.Canonicalize("https://example.org/a?p=1&utm_source=x")
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.org/a?p=1", out)
+
+	assert.NilError(t, os.WriteFile(path, []byte(`{"sites": {}}`), 0o644))
+	_, err = LoadCanonicalRules(path)
+	assert.ErrorContains(t, err, "invalid canonicalization rules")
+}
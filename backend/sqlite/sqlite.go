@@ -7,8 +7,11 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Opens a database that may be written from several connections at once,
+// such as by the server's workers or an import. Writers wait their turn
+// rather than failing with "database is locked".
 func NewFromFile(dbfile string, schema []string) (*sql.DB, error) {
-	return new(dbfile, schema)
+	return new(dbfile+"?_busy_timeout=10000&_txlock=immediate", schema)
 }
 
 func NewFromMemory(schema []string) (*sql.DB, error) {
@@ -20,6 +23,10 @@ func new(dbfile string, schema []string) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Every connection to :memory: gets its own empty database
+	if dbfile == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
 
 	err = applySchema(db, schema)
 	if err != nil {
@@ -34,6 +41,12 @@ func applySchema(db *sql.DB, schema []string) error {
 	row := db.QueryRow("SELECT schemaVersion FROM metadata WHERE id = 0")
 	_ = row.Scan(&schemaVersion)
 
+	// Tools that only need the older parts of the schema may open a
+	// database that has since been migrated further
+	if schemaVersion >= len(schema) {
+		return nil
+	}
+
 	for _, sql := range schema[schemaVersion:] {
 		_, err := db.Exec(sql)
 		if err != nil {
@@ -7,11 +7,32 @@ import (
 	"log"
 	"net/http"
 	"os/exec"
+	"slices"
 	"strings"
+	"sync"
 )
 
 type FetcherFunc func(ctx context.Context, url string) ([]byte, string, error)
 
+type redirectsKey struct{}
+
+// RecordRedirects returns a context in which successful fetches note the
+// urls they were redirected from, and a function returning those noted
+func RecordRedirects(ctx context.Context) (context.Context, func() []string) {
+	var mu sync.Mutex
+	var urls []string
+	record := func(url string) {
+		mu.Lock()
+		defer mu.Unlock()
+		urls = append(urls, url)
+	}
+	return context.WithValue(ctx, redirectsKey{}, record), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(urls)
+	}
+}
+
 func doFetch(ctx context.Context, req *http.Request) ([]byte, string, error) {
 	var httpClient http.Client
 
@@ -32,6 +53,11 @@ func doFetch(ctx context.Context, req *http.Request) ([]byte, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
+	if record, ok := ctx.Value(redirectsKey{}).(func(string)); ok {
+		for r := res.Request; r.Response != nil; r = r.Response.Request {
+			record(r.Response.Request.URL.String())
+		}
+	}
 	return body, res.Request.URL.String(), nil
 }
 
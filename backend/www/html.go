@@ -2,6 +2,9 @@ package www
 
 import (
 	"bytes"
+	"net/url"
+	"slices"
+	"strings"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -38,3 +41,57 @@ func HtmlTitle(page []byte) string {
 	}
 	return ""
 }
+
+// Returns the href of the page's <link rel="canonical">, resolved against
+// the url the page was fetched from, or "" if it has none
+func CanonicalLink(page []byte, pageURL string) string {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return ""
+	}
+
+	htmlNode := findChild(doc, atom.Html)
+	if htmlNode == nil {
+		return ""
+	}
+	headNode := findChild(htmlNode, atom.Head)
+	if headNode == nil {
+		return ""
+	}
+	for n := headNode.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Link {
+			continue
+		}
+		if !slices.Contains(strings.Fields(strings.ToLower(attr(n, "rel"))), "canonical") {
+			continue
+		}
+		href := strings.TrimSpace(attr(n, "href"))
+		base, err := url.Parse(pageURL)
+		if href == "" || err != nil {
+			return ""
+		}
+		canonical, err := base.Parse(href)
+		if err != nil {
+			return ""
+		}
+		return canonical.String()
+	}
+	return ""
+}
+
+// Reports whether the page is an AMP page, which marks itself with an amp
+// or ⚡ attribute on its <html>
+func IsAmp(page []byte) bool {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return false
+	}
+
+	htmlNode := findChild(doc, atom.Html)
+	if htmlNode == nil {
+		return false
+	}
+	return slices.ContainsFunc(htmlNode.Attr, func(a html.Attribute) bool {
+		return a.Key == "amp" || a.Key == "⚡"
+	})
+}
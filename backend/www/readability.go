@@ -0,0 +1,487 @@
+package www
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Readable is the main content of a page, stripped of navigation, comments
+// and other clutter.
+type Readable struct {
+	Title     string
+	Byline    string
+	LeadImage string
+	// HTML of the article body
+	Content []byte
+}
+
+// Patterns for classes and ids, in the spirit of Mozilla's Readability
+var (
+	unlikelyCandidate = regexp.MustCompile(`(?i)-ad-|ai2html|banner|breadcrumbs|combx|comment|community|cover-wrap|disqus|extra|footer|gdpr|header|legends|menu|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|supplemental|ad-break|agegate|pagination|pager|popup|yom-remote|cookie|consent|newsletter|subscribe|share`)
+	maybeCandidate    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveClass     = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeClass     = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget|cookie|consent|newsletter`)
+	bylineClass       = regexp.MustCompile(`(?i)byline|author|dateline|writtenby|p-author`)
+	titleSeparator    = regexp.MustCompile(` [|\-–—/»:] `)
+)
+
+// Elements that never hold article content
+var clutterTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Form: true, atom.Nav: true, atom.Footer: true, atom.Aside: true,
+	atom.Button: true, atom.Input: true, atom.Select: true, atom.Textarea: true,
+	atom.Svg: true, atom.Object: true, atom.Embed: true, atom.Link: true,
+	atom.Meta: true, atom.Template: true, atom.Dialog: true,
+}
+
+// Elements whose text is scored
+var scoredTags = map[atom.Atom]bool{
+	atom.Section: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true, atom.P: true, atom.Td: true, atom.Pre: true,
+}
+
+// Elements that make a div more than a paragraph
+var blockTags = map[atom.Atom]bool{
+	atom.Blockquote: true, atom.Dl: true, atom.Div: true, atom.Img: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Table: true, atom.Ul: true,
+	atom.Section: true, atom.Article: true, atom.Figure: true, atom.H1: true,
+	atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// Extract finds the main content of an HTML page by scoring its elements on
+// how much prose they contain, and returns it along with the title, byline
+// and lead image.
+func Extract(page []byte) (*Readable, error) {
+	doc, err := nethtml.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+	r := &Readable{
+		Title:     pageTitle(doc),
+		Byline:    metaContent(doc, "author", "article:author"),
+		LeadImage: metaContent(doc, "og:image", "twitter:image"),
+	}
+
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return nil, fmt.Errorf("page has no body")
+	}
+	removeClutter(body)
+	if byline := findByline(body); byline != nil {
+		if r.Byline == "" {
+			r.Byline = textContent(byline)
+		}
+		byline.Parent.RemoveChild(byline)
+	}
+
+	content := articleContent(body)
+	cleanContent(content, r.Title)
+	if r.LeadImage == "" {
+		if img := findElement(content, atom.Img); img != nil {
+			r.LeadImage = attr(img, "src")
+		}
+	}
+
+	var buf bytes.Buffer
+	for c := content.FirstChild; c != nil; c = c.NextSibling {
+		if err := nethtml.Render(&buf, c); err != nil {
+			return nil, err
+		}
+	}
+	r.Content = buf.Bytes()
+	return r, nil
+}
+
+// HTML returns a document holding the title, byline and lead image followed
+// by the article content, ready for conversion to markdown.
+func (r *Readable) HTML() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<html><body>")
+	if r.Title != "" {
+		fmt.Fprintf(&buf, "<h1>%s</h1>", html.EscapeString(r.Title))
+	}
+	if r.Byline != "" {
+		fmt.Fprintf(&buf, "<p><em>%s</em></p>", html.EscapeString(r.Byline))
+	}
+	if r.LeadImage != "" && !bytes.Contains(r.Content, []byte(html.EscapeString(r.LeadImage))) {
+		fmt.Fprintf(&buf, `<p><img src="%s"></p>`, html.EscapeString(r.LeadImage))
+	}
+	buf.Write(r.Content)
+	buf.WriteString("</body></html>")
+	return buf.Bytes()
+}
+
+func attr(n *nethtml.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findElement(n *nethtml.Node, a atom.Atom) *nethtml.Node {
+	if n.Type == nethtml.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func findElements(n *nethtml.Node, a atom.Atom) []*nethtml.Node {
+	var result []*nethtml.Node
+	var walk func(*nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == nethtml.ElementNode && c.DataAtom == a {
+				result = append(result, c)
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return result
+}
+
+// Returns the text of a node with runs of whitespace collapsed
+func textContent(n *nethtml.Node) string {
+	var sb strings.Builder
+	var walk func(*nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		if n.Type == nethtml.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// Returns the content of the first of the named meta tags present
+func metaContent(doc *nethtml.Node, names ...string) string {
+	metas := findElements(doc, atom.Meta)
+	for _, name := range names {
+		for _, m := range metas {
+			if attr(m, "property") == name || attr(m, "name") == name {
+				if content := strings.TrimSpace(attr(m, "content")); content != "" {
+					return content
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func pageTitle(doc *nethtml.Node) string {
+	if title := metaContent(doc, "og:title", "twitter:title"); title != "" {
+		return title
+	}
+	h1s := findElements(doc, atom.H1)
+	if len(h1s) == 1 {
+		if title := textContent(h1s[0]); title != "" {
+			return title
+		}
+	}
+	titleNode := findElement(doc, atom.Title)
+	if titleNode == nil {
+		return ""
+	}
+	title := textContent(titleNode)
+	// Drop the site name from "Headline | Site Name" unless that leaves
+	// too little to be a headline
+	if locs := titleSeparator.FindAllStringIndex(title, -1); locs != nil {
+		head := title[:locs[len(locs)-1][0]]
+		if len(strings.Fields(head)) >= 3 {
+			return head
+		}
+	}
+	return title
+}
+
+func matchString(n *nethtml.Node) string {
+	return attr(n, "class") + " " + attr(n, "id")
+}
+
+func isHidden(n *nethtml.Node) bool {
+	style := strings.ReplaceAll(attr(n, "style"), " ", "")
+	for _, a := range n.Attr {
+		if a.Key == "hidden" {
+			return true
+		}
+	}
+	return attr(n, "aria-hidden") == "true" ||
+		strings.Contains(style, "display:none") ||
+		strings.Contains(style, "visibility:hidden")
+}
+
+// Remove elements that are unlikely to be part of the article
+func removeClutter(n *nethtml.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case nethtml.CommentNode:
+			n.RemoveChild(c)
+		case nethtml.ElementNode:
+			match := matchString(c)
+			role := attr(c, "role")
+			if clutterTags[c.DataAtom] || isHidden(c) ||
+				role == "navigation" || role == "complementary" || role == "dialog" || role == "banner" ||
+				(c.DataAtom != atom.Article && c.DataAtom != atom.Main && c.DataAtom != atom.A &&
+					unlikelyCandidate.MatchString(match) && !maybeCandidate.MatchString(match)) {
+				n.RemoveChild(c)
+			} else {
+				removeClutter(c)
+			}
+		}
+		c = next
+	}
+}
+
+func findByline(n *nethtml.Node) *nethtml.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != nethtml.ElementNode {
+			continue
+		}
+		if attr(c, "rel") == "author" || strings.Contains(attr(c, "itemprop"), "author") ||
+			bylineClass.MatchString(matchString(c)) {
+			if text := textContent(c); len(text) > 0 && len(text) < 100 {
+				return c
+			}
+		}
+		if found := findByline(c); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func classWeight(n *nethtml.Node) float64 {
+	weight := 0.0
+	for _, s := range []string{attr(n, "class"), attr(n, "id")} {
+		if s == "" {
+			continue
+		}
+		if negativeClass.MatchString(s) {
+			weight -= 25
+		}
+		if positiveClass.MatchString(s) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// The fraction of a node's text that is inside links
+func linkDensity(n *nethtml.Node) float64 {
+	length := len(textContent(n))
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	for _, a := range findElements(n, atom.A) {
+		linkLength += len(textContent(a))
+	}
+	return float64(linkLength) / float64(length)
+}
+
+func initialScore(n *nethtml.Node) float64 {
+	score := classWeight(n)
+	switch n.DataAtom {
+	case atom.Div, atom.Article, atom.Main:
+		score += 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		score += 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li, atom.Form:
+		score -= 3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		score -= 5
+	}
+	return score
+}
+
+// A div with no block-level children is scored like a paragraph
+func isParagraphDiv(n *nethtml.Node) bool {
+	if n.DataAtom != atom.Div {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == nethtml.ElementNode && blockTags[c.DataAtom] {
+			return false
+		}
+	}
+	return true
+}
+
+// Scores the elements of the body and returns a new element holding the
+// best candidate for the article together with related siblings.
+func articleContent(body *nethtml.Node) *nethtml.Node {
+	scores := map[*nethtml.Node]float64{}
+	var candidates []*nethtml.Node
+
+	var walk func(*nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != nethtml.ElementNode {
+				continue
+			}
+			if scoredTags[c.DataAtom] || isParagraphDiv(c) {
+				scoreParagraph(c, scores, &candidates)
+			}
+			walk(c)
+		}
+	}
+	walk(body)
+
+	var top *nethtml.Node
+	topScore := math.Inf(-1)
+	for _, c := range candidates {
+		scores[c] *= 1 - linkDensity(c)
+		if scores[c] > topScore {
+			top, topScore = c, scores[c]
+		}
+	}
+
+	content := &nethtml.Node{Type: nethtml.ElementNode, Data: "div", DataAtom: atom.Div}
+	if top == nil || top == body {
+		for c := body.FirstChild; c != nil; {
+			next := c.NextSibling
+			body.RemoveChild(c)
+			content.AppendChild(c)
+			c = next
+		}
+		return content
+	}
+
+	// Articles are often split into several siblings, for instance by an
+	// inline ad or image
+	threshold := math.Max(10, topScore*0.2)
+	topClass := attr(top, "class")
+	parent := top.Parent
+	for s := parent.FirstChild; s != nil; {
+		next := s.NextSibling
+		if s.Type == nethtml.ElementNode && (s == top || includeSibling(s, scores, threshold, topClass, topScore)) {
+			parent.RemoveChild(s)
+			content.AppendChild(s)
+		}
+		s = next
+	}
+	return content
+}
+
+func scoreParagraph(n *nethtml.Node, scores map[*nethtml.Node]float64, candidates *[]*nethtml.Node) {
+	text := textContent(n)
+	if len(text) < 25 {
+		return
+	}
+	score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text)/100), 3)
+
+	ancestor := n.Parent
+	for level := 0; level < 3 && ancestor != nil && ancestor.Type == nethtml.ElementNode; level++ {
+		if ancestor.DataAtom == atom.Html {
+			break
+		}
+		if _, ok := scores[ancestor]; !ok {
+			scores[ancestor] = initialScore(ancestor)
+			*candidates = append(*candidates, ancestor)
+		}
+		switch level {
+		case 0:
+			scores[ancestor] += score
+		case 1:
+			scores[ancestor] += score / 2
+		default:
+			scores[ancestor] += score / float64(level*3)
+		}
+		ancestor = ancestor.Parent
+	}
+}
+
+func includeSibling(s *nethtml.Node, scores map[*nethtml.Node]float64, threshold float64, topClass string, topScore float64) bool {
+	bonus := 0.0
+	if topClass != "" && attr(s, "class") == topClass {
+		bonus = topScore * 0.2
+	}
+	if score, ok := scores[s]; ok && score+bonus >= threshold {
+		return true
+	}
+	if s.DataAtom != atom.P {
+		return false
+	}
+	text := textContent(s)
+	density := linkDensity(s)
+	if len(text) > 80 {
+		return density < 0.25
+	}
+	return len(text) > 0 && density == 0 && strings.HasSuffix(text, ".")
+}
+
+// Remove what's left of the clutter inside the chosen content
+func cleanContent(content *nethtml.Node, title string) {
+	// The title is shown separately
+	for _, tag := range []atom.Atom{atom.H1, atom.H2} {
+		for _, h := range findElements(content, tag) {
+			if classWeight(h) < 0 || (title != "" && textContent(h) == title) {
+				h.Parent.RemoveChild(h)
+			}
+		}
+	}
+
+	for _, tag := range []atom.Atom{atom.Form, atom.Table, atom.Ul, atom.Ol, atom.Div, atom.Section} {
+		elements := findElements(content, tag)
+		// Work from the innermost elements out, keeping the chosen elements
+		// themselves
+		for i := len(elements) - 1; i >= 0; i-- {
+			n := elements[i]
+			if n.Parent != nil && n.Parent != content && isClutter(n) {
+				n.Parent.RemoveChild(n)
+			}
+		}
+	}
+}
+
+func isClutter(n *nethtml.Node) bool {
+	weight := classWeight(n)
+	if weight < 0 {
+		return true
+	}
+	text := textContent(n)
+	if strings.Count(text, ",") >= 10 {
+		return false
+	}
+	paragraphs := len(findElements(n, atom.P))
+	images := len(findElements(n, atom.Img))
+	items := len(findElements(n, atom.Li))
+	inputs := len(findElements(n, atom.Input))
+	density := linkDensity(n)
+	isList := n.DataAtom == atom.Ul || n.DataAtom == atom.Ol
+
+	switch {
+	case images > 1 && float64(paragraphs)/float64(images) < 0.5:
+		return true
+	case !isList && items > paragraphs+100:
+		return true
+	case float64(inputs) > float64(paragraphs)/3:
+		return true
+	case len(text) < 25 && (images == 0 || images > 2) && findElement(n, atom.Pre) == nil:
+		return true
+	case weight < 25 && density > 0.2:
+		return true
+	case weight >= 25 && density > 0.5:
+		return true
+	}
+	return false
+}
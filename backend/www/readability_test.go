@@ -0,0 +1,55 @@
+package www
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// Formats the result of extraction for comparison with a golden file
+func goldenText(r *Readable) string {
+	return fmt.Sprintf("Title: %s\nByline: %s\nLeadImage: %s\n\n%s\n", r.Title, r.Byline, r.LeadImage, r.Content)
+}
+
+func TestExtract(t *testing.T) {
+	pages, err := filepath.Glob(filepath.Join("testdata", "readability", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 {
+		t.Fatal("no test pages found")
+	}
+
+	for _, page := range pages {
+		t.Run(filepath.Base(page), func(t *testing.T) {
+			html, err := os.ReadFile(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Extract(html)
+			if err != nil {
+				t.Fatalf("extraction failed: %v", err)
+			}
+			got := goldenText(r)
+
+			goldenPath := strings.TrimSuffix(page, ".html") + ".golden"
+			if *update {
+				if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("error reading golden file (run with -update to create it): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("extraction of %s differs from %s:\n%s", page, goldenPath, got)
+			}
+		})
+	}
+}
@@ -22,6 +22,12 @@ export function RequireAuth({ children }: { children: React.ReactNode }) {
             if (credentialResponse.credential) {
               setToken(credentialResponse.credential);
               Cookies.set("auth_token", credentialResponse.credential, { sameSite: 'Strict', secure: true });
+              // The session outlasts the credential, which expires in an hour
+              fetch('/api/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ credential: credentialResponse.credential }),
+              });
             }
           }}
           onError={() => {
@@ -6,9 +6,11 @@ export interface LocalArticle {
   url: string;
   title: string;
   contents?: string;
+  contentHash?: string; // Server's hash of the contents we have
   hasBody: boolean;
   unread: boolean;
   archived: boolean;
+  tags?: string[]; // Folders are separated by slashes
   downloadedAt: number;
   lastAccess?: number; // When the article was last accessed/read
   lastModified?: string;
@@ -68,6 +70,39 @@ export const updateLastSyncTimestamp = async (): Promise<void> => {
   });
 };
 
+// Get the server's cursor for the changes we've seen, if we have one
+export const getSyncCursor = async (): Promise<string | undefined> => {
+  const metadata = await db.metadata.get('syncCursor');
+  return metadata?.value as string | undefined;
+};
+
+// Remember the server's cursor for the changes we've seen
+export const updateSyncCursor = async (cursor: string): Promise<void> => {
+  await db.metadata.put({ key: 'syncCursor', value: cursor });
+};
+
+// Get the server's cursor for the article contents we've downloaded
+export const getContentsCursor = async (): Promise<string> => {
+  const metadata = await db.metadata.get('contentsCursor');
+  return metadata?.value as string || '';
+};
+
+// Remember the server's cursor for the article contents we've downloaded
+export const updateContentsCursor = async (cursor: string): Promise<void> => {
+  await db.metadata.put({ key: 'contentsCursor', value: cursor });
+};
+
+// Get the id identifying this device's changes to the server
+export const getDeviceId = async (): Promise<string> => {
+  const metadata = await db.metadata.get('deviceId');
+  if (metadata) {
+    return metadata.value as string;
+  }
+  const deviceId = crypto.randomUUID();
+  await db.metadata.put({ key: 'deviceId', value: deviceId });
+  return deviceId;
+};
+
 // Get all articles for display
 export const getAllArticles = async (): Promise<LocalArticle[]> => {
   return await db.articles.orderBy('downloadedAt').reverse().toArray();
@@ -110,6 +145,11 @@ export const getArticle = async (url: string): Promise<LocalArticle | undefined>
   return await db.articles.get(url);
 };
 
+// Remove an article that is no longer in the library
+export const deleteArticle = async (url: string): Promise<void> => {
+  await db.articles.delete(url);
+};
+
 // Check if article exists locally
 export const hasArticle = async (url: string): Promise<boolean> => {
   const count = await db.articles.where('url').equals(url).count();
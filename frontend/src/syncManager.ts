@@ -10,10 +10,100 @@ import {
   getArticle,
   markArticleRead,
   setArticleArchive,
-  articleToLocal
+  deleteArticle,
+  getDeviceId,
+  getSyncCursor,
+  updateSyncCursor,
+  getContentsCursor,
+  updateContentsCursor,
+  articleToLocal,
+  SyncQueueItem
 } from './database';
 import { Article } from './Article';
 
+// A change to a field of an article made on this device
+interface Mutation {
+  url: string;
+  field: 'unread' | 'archived';
+  value: boolean;
+  timestamp: number;
+}
+
+interface MutationResult {
+  url: string;
+  field: string;
+  applied: boolean;
+  error?: string;
+}
+
+interface ServerChanges {
+  changed: LocalArticle[];
+  deleted: string[];
+}
+
+interface SyncResult {
+  results: MutationResult[];
+  changes: ServerChanges;
+  cursor: string;
+}
+
+type ChangeItem = {
+  url: string;
+  title: string;
+  hasBody: boolean;
+  unread: boolean;
+  archived: boolean;
+  lastAccess: string;
+  deleted?: boolean;
+  tags?: string[];
+  contentHash?: string;
+  contents?: string;
+};
+
+interface ContentsPage {
+  changes: ChangeItem[];
+  cursor: string;
+  more: boolean;
+}
+
+// Convert a server change list to local articles and deleted urls
+const parseChanges = (data: unknown): ServerChanges => {
+  // Handle null/undefined response or empty array
+  if (!data || !Array.isArray(data)) {
+    console.log('SyncManager: No changes found or invalid response');
+    return { changed: [], deleted: [] };
+  }
+
+  // Articles removed from the library on the server come back as tombstones
+  const deleted = data.filter((item: ChangeItem) => item.deleted).map((item: ChangeItem) => item.url);
+
+  // Convert server response to LocalArticle format
+  const changed = data.filter((item: ChangeItem) => !item.deleted).map((item: ChangeItem) => ({
+    url: item.url,
+    title: item.title,
+    hasBody: item.hasBody, // Server has content
+    unread: item.unread,
+    archived: item.archived,
+    tags: item.tags ?? [],
+    lastAccess: new Date(item.lastAccess).getTime(), // Convert server timestamp to local timestamp
+    downloadedAt: Date.now(),
+    contents: undefined // Server doesn't send full content in changes
+  }));
+  return { changed, deleted };
+};
+
+// Convert a queued operation to the mutation it makes
+const toMutation = (item: SyncQueueItem): Mutation | undefined => {
+  switch (item.operation) {
+    case 'markRead':
+      return { url: item.url, field: 'unread', value: false, timestamp: item.timestamp };
+    case 'setArchive':
+      return { url: item.url, field: 'archived', value: item.data.archived as boolean, timestamp: item.timestamp };
+  }
+  // Note: 'download' operation is handled differently as it's server->client
+  return undefined;
+};
+
 // API client for server communication
 class ApiClient {
   private baseUrl: string;
@@ -22,37 +112,39 @@ class ApiClient {
     this.baseUrl = baseUrl;
   }
 
-  async fetchChanges(since: string): Promise<LocalArticle[]> {
-    const response = await fetch(`${this.baseUrl}/api/changes?since=${encodeURIComponent(since)}`);
+  // Fetch a page of changes with the articles' contents. Contents we've
+  // already been sent are left out, but their hash is still given.
+  async fetchContents(cursor: string): Promise<ContentsPage> {
+    const response = await fetch(`${this.baseUrl}/api/changes?include=contents&cursor=${encodeURIComponent(cursor)}`);
     if (!response.ok) {
-      throw new Error(`Failed to fetch changes: ${response.statusText}`);
+      throw new Error(`Failed to fetch contents: ${response.statusText}`);
     }
     const data = await response.json();
-    
-    // Handle null/undefined response or empty array
-    if (!data || !Array.isArray(data)) {
-      console.log('SyncManager: No changes found or invalid response');
-      return [];
+    return {
+      changes: data.changes || [],
+      cursor: data.cursor,
+      more: data.more
+    };
+  }
+
+  // Send a batch of local changes and receive the server's changes since the
+  // last sync. The server's cursor is used if we have one, else the time of
+  // the last sync.
+  async sync(deviceId: string, cursor: string | undefined, since: string, mutations: Mutation[]): Promise<SyncResult> {
+    const response = await fetch(`${this.baseUrl}/api/sync`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ deviceId, clientTime: Date.now(), cursor, since, mutations })
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to sync: ${response.statusText}`);
     }
-    
-    // Convert server response to LocalArticle format
-    return data.map((item: {
-      url: string;
-      title: string;
-      hasBody: boolean;
-      unread: boolean;
-      archived: boolean;
-      lastAccess: string;
-    }) => ({
-      url: item.url,
-      title: item.title,
-      hasBody: item.hasBody, // Server has content
-      unread: item.unread,
-      archived: item.archived,
-      lastAccess: new Date(item.lastAccess).getTime(), // Convert server timestamp to local timestamp
-      downloadedAt: Date.now(),
-      contents: undefined // Server doesn't send full content in changes
-    }));
+    const data = await response.json();
+    return {
+      results: data.results || [],
+      changes: parseChanges(data.changes),
+      cursor: data.cursor
+    };
   }
 
   async markRead(url: string): Promise<void> {
@@ -167,10 +259,12 @@ export class SyncManager {
   private syncInProgress = false;
   private statusCallbacks: Set<SyncStatusCallback> = new Set();
   private syncInterval?: number;
+  private eventSource?: EventSource;
 
   constructor() {
     this.apiClient = new ApiClient();
     this.setupPeriodicSync();
+    this.setupEventStream();
   }
 
   // Subscribe to sync status updates
@@ -225,6 +319,31 @@ export class SyncManager {
     });
   }
 
+  // Apply changes pushed by the server as they happen. The browser
+  // reconnects by itself, resuming from the last event it saw.
+  private setupEventStream() {
+    if (typeof EventSource === 'undefined') {
+      return;
+    }
+    this.eventSource = new EventSource('/api/events');
+    this.eventSource.addEventListener('changes', (event: MessageEvent) => {
+      this.applyPushedChanges(parseChanges(JSON.parse(event.data))).catch(console.error);
+    });
+  }
+
+  private async applyPushedChanges(changes: ServerChanges): Promise<void> {
+    for (const serverArticle of changes.changed) {
+      await this.resolveAndMerge(serverArticle);
+    }
+    for (const url of changes.deleted) {
+      await deleteArticle(url);
+    }
+    if (changes.changed.some(article => article.hasBody)) {
+      await this.syncContents();
+    }
+    await this.notifyStatusChange();
+  }
+
   // Perform full bidirectional sync
   async performFullSync(): Promise<void> {
     if (this.syncInProgress || !navigator.onLine) {
@@ -235,13 +354,13 @@ export class SyncManager {
     await this.notifyStatusChange();
 
     try {
-      // 1. Sync pending local changes to server
-      await this.syncToServer();
+      // Exchange local and server changes in one batch
+      await this.syncWithServer();
       
-      // 2. Sync server changes to local
-      await this.syncFromServer();
+      // Fill the offline cache with the contents of changed articles
+      await this.syncContents();
       
-      // 3. Update last sync timestamp
+      // Update last sync timestamp
       await updateLastSyncTimestamp();
       
     } catch (error) {
@@ -261,52 +380,62 @@ export class SyncManager {
     }
   }
 
-  // Sync local changes to server
-  private async syncToServer(): Promise<void> {
+  // Send pending local changes to the server and apply the server's changes
+  // locally. The server resolves conflicts between devices using the time
+  // each change was queued.
+  private async syncWithServer(): Promise<void> {
     const pendingItems = await getPendingSyncItems();
-    
+    const deviceId = await getDeviceId();
+    const cursor = await getSyncCursor();
+    const lastSync = await getLastSyncTimestamp();
+
+    const mutations: Mutation[] = [];
     for (const item of pendingItems) {
-      try {
-        switch (item.operation) {
-          case 'markRead':
-            await this.apiClient.markRead(item.url);
-            break;
-          case 'setArchive':
-            await this.apiClient.setArchive(item.url, item.data.archived as boolean);
-            break;
-          // Note: 'download' operation is handled differently as it's server->client
-        }
-        
-        // Remove successful item from queue
-        if (item.id) {
-          await removeSyncItem(item.id);
-        }
-      } catch (error) {
-        console.error(`Failed to sync ${item.operation} for ${item.url}:`, error);
-        
-        // Increment retry count
+      const mutation = toMutation(item);
+      if (mutation) {
+        mutations.push(mutation);
+      }
+    }
+
+    let result: SyncResult;
+    try {
+      result = await this.apiClient.sync(deviceId, cursor, lastSync, mutations);
+    } catch (error) {
+      // Count the failure against every pending item, giving up on items
+      // after too many failures (5 retries)
+      for (const item of pendingItems) {
         if (item.id) {
           await incrementSyncRetry(item.id);
-          
-          // Remove after too many failures (5 retries)
           if (item.retryCount >= 5) {
             await removeSyncItem(item.id);
           }
         }
       }
+      throw error;
+    }
+
+    // Every item the server saw is done with, whether or not it won
+    for (const item of pendingItems) {
+      if (item.id) {
+        await removeSyncItem(item.id);
+      }
+    }
+    for (const r of result.results) {
+      if (r.error) {
+        console.error(`Failed to sync ${r.field} for ${r.url}:`, r.error);
+      }
     }
-  }
 
-  // Sync server changes to local
-  private async syncFromServer(): Promise<void> {
-    const lastSync = await getLastSyncTimestamp();
-    const serverChanges = await this.apiClient.fetchChanges(lastSync);
-    
     let changesApplied = 0;
-    for (const serverArticle of serverChanges) {
+    for (const serverArticle of result.changes.changed) {
       await this.resolveAndMerge(serverArticle);
       changesApplied++;
     }
+    for (const url of result.changes.deleted) {
+      await deleteArticle(url);
+      changesApplied++;
+    }
+    await updateSyncCursor(result.cursor);
     
     // Notify status change if we applied any changes from server
     if (changesApplied > 0) {
@@ -315,6 +444,36 @@ export class SyncManager {
     }
   }
 
+  // Download the contents of articles that changed since we last did,
+  // a page at a time
+  private async syncContents(): Promise<void> {
+    let cursor = await getContentsCursor();
+    for (;;) {
+      const page = await this.apiClient.fetchContents(cursor);
+      for (const item of page.changes) {
+        if (item.deleted || !item.contents) {
+          continue;
+        }
+        const localArticle = await getArticle(item.url);
+        // Skip bodies we already have
+        if (!localArticle || localArticle.contentHash === item.contentHash) {
+          continue;
+        }
+        await storeArticle({
+          ...localArticle,
+          contents: item.contents,
+          contentHash: item.contentHash,
+          hasBody: true
+        });
+      }
+      cursor = page.cursor;
+      await updateContentsCursor(cursor);
+      if (!page.more) {
+        break;
+      }
+    }
+  }
+
   // Three-way merge for conflict resolution
   private async resolveAndMerge(serverArticle: LocalArticle): Promise<void> {
     const localArticle = await getArticle(serverArticle.url);
@@ -476,6 +635,7 @@ export class SyncManager {
     if (this.syncInterval) {
       clearInterval(this.syncInterval);
     }
+    this.eventSource?.close();
     this.statusCallbacks.clear();
   }
 }